@@ -1,17 +1,24 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 
 	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/filter"
 )
 
 var apiCmd = &cobra.Command{
 	Use:   "api",
 	Short: "Interact with the RavenPair REST API",
-	Long:  `Send requests to the RavenPair server REST API endpoints.`,
+	Long: `Send requests to the RavenPair server REST API endpoints.
+
+Use --filter to run a jq expression over the JSON response, for example
+  ravenpair api list --filter '.[].name'`,
 }
 
 var statusCmd = &cobra.Command{
@@ -40,6 +47,7 @@ func init() {
 	apiCmd.AddCommand(statusCmd)
 	apiCmd.AddCommand(pairCmd)
 	apiCmd.AddCommand(listCmd)
+	apiCmd.PersistentFlags().String("filter", "", "jq expression to apply to the JSON response")
 
 	pairCmd.Flags().String("name", "", "name for the pair session")
 }
@@ -58,27 +66,48 @@ func printJSON(w io.Writer, data []byte) {
 	fmt.Fprintln(w, string(pretty))
 }
 
+// printResponse prints an API response, passing its body through the jq
+// expression given with --filter, if any. Each output is printed on its own,
+// as jq would.
+func printResponse(cmd *cobra.Command, statusCode int, body []byte) error {
+	var prog *filter.Program
+	if f := cmd.Flag("filter"); f != nil && f.Value.String() != "" {
+		var err error
+		if prog, err = filter.Compile(f.Value.String()); err != nil {
+			return err
+		}
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "HTTP %d\n", statusCode)
+	if prog == nil {
+		printJSON(out, body)
+		return nil
+	}
+	outs, err := prog.RunJSON(context.Background(), body)
+	if err != nil {
+		return err
+	}
+	for _, o := range outs {
+		printJSON(out, o)
+	}
+	return nil
+}
+
 func runStatus(cmd *cobra.Command, args []string) error {
 	statusCode, body, err := svc.API.GetStatus()
 	if err != nil {
 		return err
 	}
-	out := cmd.OutOrStdout()
-	fmt.Fprintf(out, "HTTP %d\n", statusCode)
-	printJSON(out, body)
-	return nil
+	return printResponse(cmd, statusCode, body)
 }
 
 func runPair(cmd *cobra.Command, args []string) error {
-	name, _ := cmd.Flags().GetString("name")
+	name := stringSetting(cmd, "name", "pair.name")
 	statusCode, body, err := svc.API.CreatePair(name)
 	if err != nil {
 		return err
 	}
-	out := cmd.OutOrStdout()
-	fmt.Fprintf(out, "HTTP %d\n", statusCode)
-	printJSON(out, body)
-	return nil
+	return printResponse(cmd, statusCode, body)
 }
 
 func runList(cmd *cobra.Command, args []string) error {
@@ -86,8 +115,29 @@ func runList(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	out := cmd.OutOrStdout()
-	fmt.Fprintf(out, "HTTP %d\n", statusCode)
-	printJSON(out, body)
-	return nil
+	return printResponse(cmd, statusCode, body)
+}
+
+// createPair creates a pair session and returns its ID, for commands that
+// start one when no pair is given.
+func createPair(name string) (string, error) {
+	statusCode, body, err := svc.API.CreatePair(name)
+	if err != nil {
+		return "", err
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return "", fmt.Errorf("creating pair: HTTP %d: %s", statusCode, bytes.TrimSpace(body))
+	}
+	var pair struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(body, &pair); err != nil || len(pair.ID) == 0 {
+		return "", fmt.Errorf("creating pair: no pair ID in response %s", bytes.TrimSpace(body))
+	}
+	// IDs may be strings or numbers.
+	var id string
+	if json.Unmarshal(pair.ID, &id) != nil {
+		id = string(pair.ID)
+	}
+	return id, nil
 }
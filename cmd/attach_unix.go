@@ -0,0 +1,201 @@
+//go:build unix
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/asciicast"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// detachKey is Ctrl+], which detaches from a shared terminal, as in telnet.
+const detachKey = 0x1d
+
+var attachCmd = &cobra.Command{
+	Use:   "attach <pair>",
+	Short: "Watch or type into a terminal shared with \"ravenpair share\"",
+	Long: `Render the terminal shared in a pair session with "ravenpair share" and send
+your keystrokes to it. Your terminal is put in raw mode, so every key, Ctrl+C
+included, goes to the shared shell; press Ctrl+] to detach. The host only
+types what drivers send; role changes are shown as they are announced.
+
+--record saves what is shown as an asciicast v2 recording, which
+"ravenpair play" or asciinema can replay.
+
+With --read-only nothing is sent: your terminal stays as it is and Ctrl+C
+detaches. The shared terminal is drawn at the size of the host's; if yours is
+smaller, attach warns that the output may not render correctly.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAttach,
+}
+
+func init() {
+	rootCmd.AddCommand(attachCmd)
+	attachCmd.Flags().Bool("read-only", false, "only watch; do not send keystrokes")
+	attachCmd.Flags().String("record", "", "also record the session to this asciicast v2 file")
+	addSessionFlags(attachCmd)
+}
+
+func runAttach(cmd *cobra.Command, args []string) error {
+	pairID := args[0]
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	in, out, errOut := cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	local := openLocalTerminal(in)
+	defer local.restore()
+	cols, rows := local.size()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+
+	// The recording starts once the session is open, so that a failed
+	// connection leaves no empty recording behind.
+	var rec *asciicast.Writer
+	defer func() { stopRecording(cmd, rec) }()
+
+	detached := make(chan struct{})
+	// warnSize warns once that the local terminal is smaller than the shared
+	// one.
+	warned := false
+	warnSize := func(p protocol.TermSizePayload) {
+		if local == nil || warned {
+			return
+		}
+		if cols, rows := local.size(); cols < p.Cols || rows < p.Rows {
+			warned = true
+			fmt.Fprintf(errOut, "attach: the shared terminal is %dx%d but yours is %dx%d; output may not render correctly%s",
+				p.Cols, p.Rows, cols, rows, local.newline())
+		}
+	}
+
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Opened:
+				if rec, err = startRecording(cmd, cols, rows, "ravenpair attach "+pairID); err != nil {
+					sess.Close(ports.CloseNormalClosure, "")
+					return err
+				}
+				if readOnly {
+					fmt.Fprintf(out, "Watching pair %s. Press Ctrl+C to stop.\n", pairID)
+					continue
+				}
+				fmt.Fprintf(out, "Attached to pair %s. Press Ctrl+] to detach.\n", pairID)
+				if err := local.makeRaw(); err != nil {
+					fmt.Fprintf(errOut, "attach: cannot switch the terminal to raw mode: %v\n", err)
+				}
+				go sendKeystrokes(ctx, in, sess, pairID, detached)
+			case ports.Message:
+				if e.Type != ports.TextMessage {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil {
+					continue
+				}
+				switch event.Type {
+				case protocol.TypeTermOutput:
+					var p protocol.TermDataPayload
+					if event.DecodePayload(&p) == nil {
+						out.Write(p.Data)
+						if rec != nil {
+							rec.Output(p.Data)
+						}
+					}
+				case protocol.TypeTermStart, protocol.TypeTermResize:
+					var p protocol.TermSizePayload
+					if event.DecodePayload(&p) != nil {
+						continue
+					}
+					if line, ok := protocol.Format(event); ok && event.Type == protocol.TypeTermStart {
+						fmt.Fprint(out, line+local.newline())
+					}
+					warnSize(p)
+					if rec != nil {
+						rec.Resize(int(p.Cols), int(p.Rows))
+					}
+				case protocol.TypeTermRole:
+					if line, ok := protocol.Format(event); ok {
+						fmt.Fprint(out, line+local.newline())
+					}
+				case protocol.TypeTermEnd:
+					local.restore()
+					if line, ok := protocol.Format(event); ok {
+						fmt.Fprintln(out, "\n"+line)
+					}
+					if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+						return err
+					}
+				}
+			case ports.Closed:
+				local.restore()
+				if e.Local {
+					fmt.Fprintf(out, "Connection closed: %s\n", describeClose(e))
+					return nil
+				}
+				return serverClosed(errOut, e)
+			case ports.Error:
+				local.restore()
+				fmt.Fprintf(errOut, "connection error: %v\n", e.Err)
+				return e.Err
+			}
+		case <-detached:
+			detached = nil
+			local.restore()
+			fmt.Fprintln(out, "\nDetached. Closing connection...")
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			local.restore()
+			fmt.Fprintln(out, "\nInterrupted. Closing connection...")
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendKeystrokes sends what is typed as term.input events until the detach
+// key is pressed or stdin ends, then closes detached.
+func sendKeystrokes(ctx context.Context, in io.Reader, sess ports.Session, pairID string, detached chan<- struct{}) {
+	defer close(detached)
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		keys := buf[:n]
+		i := bytes.IndexByte(keys, detachKey)
+		if i >= 0 {
+			keys = keys[:i]
+		}
+		if len(keys) > 0 {
+			if err := sendEvent(ctx, sess, protocol.TypeTermInput, pairID, protocol.TermDataPayload{Data: keys}); err != nil {
+				return
+			}
+		}
+		if i >= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// binaryFormats lists the ways connect can show binary messages.
+var binaryFormats = []string{"summary", "hexdump", "base64", "raw", "save"}
+
+// sniffedExtensions maps the media types reported by http.DetectContentType
+// to the file extension used when saving a message. Other types fall back to
+// the system MIME table, then to .bin.
+var sniffedExtensions = map[string]string{
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+	"application/pdf":          ".pdf",
+	"application/wasm":         ".wasm",
+	"application/x-gzip":       ".gz",
+	"application/zip":          ".zip",
+	"image/bmp":                ".bmp",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/webp":               ".webp",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+	"video/mp4":                ".mp4",
+}
+
+// binaryWriter shows binary messages in the format chosen with
+// --binary-format.
+type binaryWriter struct {
+	out    io.Writer
+	format string
+	dir    string
+	next   int
+}
+
+func newBinaryWriter(out io.Writer, format, dir string) (*binaryWriter, error) {
+	if format == "" {
+		format = "summary"
+	}
+	valid := false
+	for _, f := range binaryFormats {
+		valid = valid || f == format
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown binary format %q: use one of %s", format, strings.Join(binaryFormats, ", "))
+	}
+	if dir == "" {
+		dir = "."
+	}
+	return &binaryWriter{out: out, format: format, dir: dir, next: 1}, nil
+}
+
+// write shows a binary message of size bytes read from r; a negative size
+// means the size is not known in advance, as for streamed messages. It returns
+// the number of bytes read.
+func (b *binaryWriter) write(r io.Reader, size int64) (int64, error) {
+	switch b.format {
+	case "hexdump":
+		if size >= 0 {
+			fmt.Fprintf(b.out, "< [binary %d bytes]\n", size)
+		} else {
+			fmt.Fprintln(b.out, "< [binary]")
+		}
+		d := hex.Dumper(b.out)
+		n, err := io.Copy(d, r)
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+		return n, err
+	case "base64":
+		fmt.Fprint(b.out, "< ")
+		enc := base64.NewEncoder(base64.StdEncoding, b.out)
+		n, err := io.Copy(enc, r)
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		fmt.Fprintln(b.out)
+		return n, err
+	case "raw":
+		return io.Copy(b.out, r)
+	case "save":
+		return b.save(r)
+	default:
+		n, err := io.Copy(io.Discard, r)
+		fmt.Fprintf(b.out, "< [binary %d bytes]\n", n)
+		return n, err
+	}
+}
+
+// save writes a message to the next free numbered file in the save directory,
+// named after the type sniffed from its first bytes.
+func (b *binaryWriter) save(r io.Reader) (int64, error) {
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	ext := sniffExtension(head)
+
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return 0, err
+	}
+	var f *os.File
+	for {
+		path := filepath.Join(b.dir, fmt.Sprintf("frame-%06d%s", b.next, ext))
+		b.next++
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return 0, err
+		}
+	}
+	n, err := io.Copy(f, br)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, fmt.Errorf("saving %s: %w", f.Name(), err)
+	}
+	fmt.Fprintf(b.out, "< [binary %d bytes saved to %s]\n", n, f.Name())
+	return n, nil
+}
+
+// sniffExtension picks a file extension for data from its content.
+func sniffExtension(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := sniffedExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
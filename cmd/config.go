@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/config"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the CLI configuration",
+	Long:  `Inspect the settings the CLI resolves from flags, environment and config files.`,
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the effective configuration",
+	Long: `Show every effective setting.
+
+Settings are layered, from lowest to highest precedence:
+  1. built-in defaults
+  2. the home config ($HOME/.ravenpair.yaml or --config)
+  3. the project config (.ravenpair.yaml or ravenpair.toml in the working
+     directory or the nearest parent), which may only set the pair names,
+     connect.path and transfer.rate_limit
+  4. RAVENPAIR_* environment variables
+  5. command-line flags
+
+Use --show-origin to see which layer each value comes from.`,
+	RunE: runConfigView,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+
+	configViewCmd.Flags().Bool("show-origin", false, "show where each setting comes from")
+}
+
+func runConfigView(cmd *cobra.Command, args []string) error {
+	showOrigin, _ := cmd.Flags().GetBool("show-origin")
+
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+
+	out := cmd.OutOrStdout()
+	for _, key := range keys {
+		value := config.Redacted(key, viper.Get(key))
+		if showOrigin {
+			fmt.Fprintf(out, "%s: %s\t(%s)\n", key, value, settingOrigin(cmd, key))
+			continue
+		}
+		fmt.Fprintf(out, "%s: %s\n", key, value)
+	}
+	return nil
+}
+
+// settingOrigin names the highest-precedence layer that provides key.
+func settingOrigin(cmd *cobra.Command, key string) string {
+	if f := cmd.Flags().Lookup(key); f != nil && f.Changed {
+		return "flag --" + key
+	}
+	env := "RAVENPAIR_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+	if projectConfigKeys[key] {
+		return "project config " + projectConfigFile
+	}
+	if homeConfigKeys[key] {
+		return "home config " + homeConfigFile
+	}
+	return "default"
+}
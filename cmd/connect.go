@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/codec"
+	"github.com/ravenpair/cli/internal/filter"
+	"github.com/ravenpair/cli/internal/ports"
 )
 
 var connectCmd = &cobra.Command{
@@ -16,56 +22,384 @@ var connectCmd = &cobra.Command{
 	Short: "Open a WebSocket connection to the RavenPair server",
 	Long: `Establish a persistent WebSocket connection to the RavenPair server.
 Messages received from the server are printed to stdout.
-Press Ctrl+C to close the connection.`,
+Press Ctrl+C (or send SIGTERM) to close the connection.
+
+--filter and --transform take jq expressions that run on every JSON text
+message before it is printed. A message is kept when the filter yields a
+value other than false or null; the transform replaces it with each of its
+outputs. Messages that are not JSON, binary messages and messages larger than
+--stream-threshold are passed through or dropped according to --non-json.
+
+  ravenpair connect --filter '.type == "chat"' --transform '.payload.text'
+
+Known events such as join, leave, chat, cursor and file_change are shown as
+readable lines; anything else, and everything with --raw or --transform, is
+printed as received. With --strict, messages that do not match the event
+envelope schema are reported on stderr and skipped.
+
+Binary messages are decoded with the codec chosen by --codec, or by the
+negotiated subprotocol when its name or its suffix after "+" names a codec,
+as in ravenpair.v2+msgpack. Decoded messages are filtered and rendered like
+JSON ones, and messages given with --send are encoded the same way. The
+protobuf codec needs a descriptor set (protoc --descriptor_set_out
+--include_imports) and the message type:
+
+  ravenpair connect --subprotocol ravenpair.v2+cbor
+  ravenpair connect --codec protobuf --proto-descriptor-set events.pb \
+      --proto-message ravenpair.v1.Envelope
+
+Binary messages that no codec decodes are summarised by size unless
+--binary-format says otherwise: hexdump and base64 print their content, raw
+copies it to stdout as is and save writes each to a numbered file in
+--save-dir, with an extension chosen from its content (frame-000001.png).
+
+With --pipe, connect becomes a building block for scripts: every message
+read from stdin is sent and every message received is written to stdout
+unmodified, with nothing else printed there. By default each line is a text
+message; with --pipe-framing length each message is preceded by its size as a
+4-byte big-endian integer, both ways, and stdin messages are sent as binary.
+The connection is closed once stdin ends. Rendering, filtering and codec
+options do not apply.
+
+  printf '{"type":"chat","payload":{"text":"hi"}}\n' | ravenpair connect --pipe
+
+--exec runs a command for every message instead of printing it, after
+decoding, --strict validation and --filter/--transform. The message is on
+the command's stdin; RAVENPAIR_MESSAGE_TYPE (text or binary),
+RAVENPAIR_MESSAGE_SIZE, RAVENPAIR_MESSAGE_SEQ and, for event envelopes,
+RAVENPAIR_EVENT_TYPE describe it. The command line is split like a shell
+would, but not run through one; use sh -c '...' for pipelines. Commands are
+killed after --exec-timeout. While --exec-concurrency commands are running,
+up to --queue-size messages wait for their turn; further ones are dropped.
+
+With --exec-persistent the command is started once and reads one JSON object
+per message on stdin: {"seq":1,"type":"text","size":42,"payload":...}. JSON
+payloads appear as they are, other text as a string and binary messages as
+base64 with "encoding":"base64"; binary messages a binary codec decoded keep
+their type and appear as JSON. Up to --queue-size messages wait for the
+command to read them; further ones are dropped. Each line the command prints
+is sent back as a message, encoded with the codec if it is a binary one. The
+connection is closed when the command exits; --exec-timeout does not apply.
+
+  ravenpair connect --filter '.type == "chat"' --exec 'notify-send RavenPair'
+  ravenpair connect --exec-persistent --exec './bot.py'
+
+Exit status:
+  0  the connection was closed normally or interrupted
+  1  the connection could not be established or was lost
+  3  the server is going away (close code 1001)
+  4  the server closed the connection for a policy violation (1008)
+  5  authentication failed (HTTP 401/403, or close code 4401/4403)
+  6  the server closed the connection with any other status`,
 	RunE: runConnect,
 }
 
 func init() {
 	rootCmd.AddCommand(connectCmd)
-	connectCmd.Flags().String("path", "/ws", "WebSocket endpoint path")
+	addSessionFlags(connectCmd)
+	connectCmd.Flags().String("stream-threshold", "1MiB", "messages larger than this are streamed instead of buffered in memory")
+	connectCmd.Flags().Int("queue-size", 256, "how many received messages may wait to be printed")
+	connectCmd.Flags().String("overflow", "block", "what to do when the queue is full: block, drop-oldest, drop-newest or disconnect")
+	connectCmd.Flags().Bool("compress", false, "require permessage-deflate compression")
+	connectCmd.Flags().Int("compression-level", 1, "deflate level for sent messages, from -2 (Huffman only) through 0 (none) to 9 (best)")
+	connectCmd.Flags().StringArray("subprotocol", nil, "subprotocol to offer, in order of preference (repeatable); the server must accept one")
+	connectCmd.Flags().String("filter", "", "jq expression selecting which JSON messages to print")
+	connectCmd.Flags().String("transform", "", "jq expression rewriting each JSON message before it is printed")
+	connectCmd.Flags().String("non-json", "pass", "what --filter and --transform do with messages that are not JSON: pass or drop")
+	connectCmd.Flags().Bool("raw", false, "print messages as received instead of rendering known events")
+	connectCmd.Flags().Bool("strict", false, "skip messages that do not match the event envelope schema")
+	connectCmd.Flags().String("codec", "", "message encoding: json, msgpack, cbor or protobuf (default: chosen by the subprotocol, else json)")
+	connectCmd.Flags().String("proto-descriptor-set", "", "Protobuf descriptor set file for the protobuf codec")
+	connectCmd.Flags().String("proto-message", "", "full name of the Protobuf message type, such as ravenpair.v1.Envelope")
+	connectCmd.Flags().String("binary-format", "summary", "how to show binary messages: summary, hexdump, base64, raw or save")
+	connectCmd.Flags().String("save-dir", ".", "directory where --binary-format save writes numbered files")
+	connectCmd.Flags().Bool("pipe", false, "relay messages between stdin and stdout without banners or prefixes")
+	connectCmd.Flags().String("pipe-framing", "lines", "message framing for --pipe: lines or length (4-byte big-endian size prefix)")
+	connectCmd.Flags().String("exec", "", "command to run for each message, with the payload on stdin")
+	connectCmd.Flags().Bool("exec-persistent", false, "run the --exec command once, feeding it NDJSON and sending back its output lines")
+	connectCmd.Flags().Int("exec-concurrency", 1, "how many --exec commands may run at once")
+	connectCmd.Flags().Duration("exec-timeout", 30*time.Second, "how long each --exec command may run; 0 for no limit")
+	connectCmd.Flags().StringArray("send", nil, "JSON message to send once connected, encoded with the codec (repeatable)")
+}
+
+// connectDialOptions builds the session options from the connect flags.
+func connectDialOptions(cmd *cobra.Command) (ports.DialOptions, error) {
+	var opts ports.DialOptions
+	var err error
+
+	opts.CloseTimeout, _ = cmd.Flags().GetDuration("close-timeout")
+	if opts.MaxMessageSize, err = byteSizeFlag(cmd, "max-message-size"); err != nil {
+		return opts, err
+	}
+	if opts.StreamThreshold, err = byteSizeFlag(cmd, "stream-threshold"); err != nil {
+		return opts, err
+	}
+	opts.QueueSize, _ = cmd.Flags().GetInt("queue-size")
+	if name, _ := cmd.Flags().GetString("overflow"); name != "" {
+		if opts.Overflow, err = ports.ParseOverflowPolicy(name); err != nil {
+			return opts, err
+		}
+	}
+	opts.Subprotocols, _ = cmd.Flags().GetStringArray("subprotocol")
+	opts.Compression, _ = cmd.Flags().GetBool("compress")
+	if cmd.Flags().Changed("compression-level") {
+		level, _ := cmd.Flags().GetInt("compression-level")
+		opts.CompressionLevel = &level
+	}
+	return opts, nil
+}
+
+// connectPipeline compiles the --filter and --transform expressions.
+func connectPipeline(cmd *cobra.Command) (*filter.Pipeline, error) {
+	p := &filter.Pipeline{}
+	var err error
+	if expr, _ := cmd.Flags().GetString("filter"); expr != "" {
+		if p.Filter, err = filter.Compile(expr); err != nil {
+			return nil, err
+		}
+	}
+	if expr, _ := cmd.Flags().GetString("transform"); expr != "" {
+		if p.Transform, err = filter.Compile(expr); err != nil {
+			return nil, err
+		}
+	}
+	if name, _ := cmd.Flags().GetString("non-json"); name != "" {
+		if p.NonJSON, err = filter.ParseNonJSONPolicy(name); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// connectCodecs returns the codecs available to connect, including Protobuf
+// when a descriptor set is given, and the codec chosen with --codec, if any.
+func connectCodecs(cmd *cobra.Command) (*codec.Registry, codec.Codec, error) {
+	codecs := codec.NewRegistry()
+	descriptors, _ := cmd.Flags().GetString("proto-descriptor-set")
+	message, _ := cmd.Flags().GetString("proto-message")
+	if (descriptors == "") != (message == "") {
+		return nil, nil, fmt.Errorf("--proto-descriptor-set and --proto-message must be given together")
+	}
+	if descriptors != "" {
+		pb, err := codec.NewProtobuf(descriptors, message)
+		if err != nil {
+			return nil, nil, err
+		}
+		codecs.Register(pb)
+	}
+	name, _ := cmd.Flags().GetString("codec")
+	if name == "" {
+		return codecs, nil, nil
+	}
+	forced, err := codecs.Lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return codecs, forced, nil
+}
+
+// chooseCodec picks the codec of a session: the one given with --codec, else
+// the one named by the negotiated subprotocol, else JSON.
+func chooseCodec(codecs *codec.Registry, forced codec.Codec, subprotocol string) codec.Codec {
+	if forced != nil {
+		return forced
+	}
+	if c, ok := codecs.ForSubprotocol(subprotocol); ok {
+		return c
+	}
+	return codec.JSON
+}
+
+// sendAll encodes each JSON document with c and sends it, in a binary frame
+// when the codec is binary.
+func sendAll(ctx context.Context, sess ports.Session, c codec.Codec, docs []string) error {
+	msgType := ports.TextMessage
+	if c.Binary() {
+		msgType = ports.BinaryMessage
+	}
+	for _, doc := range docs {
+		data, err := c.Encode([]byte(doc))
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", c.Name(), err)
+		}
+		if err := sess.Send(ctx, msgType, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execOptions configures how --exec runs its command.
+type execOptions struct {
+	persistent  bool
+	concurrency int
+	timeout     time.Duration
+}
+
+// connectExecOptions parses --exec and the flags that go with it. It returns
+// nil arguments when --exec is not given.
+func connectExecOptions(cmd *cobra.Command) ([]string, execOptions, error) {
+	var opts execOptions
+	opts.persistent, _ = cmd.Flags().GetBool("exec-persistent")
+	opts.concurrency, _ = cmd.Flags().GetInt("exec-concurrency")
+	opts.timeout, _ = cmd.Flags().GetDuration("exec-timeout")
+	if opts.persistent && cmd.Flags().Changed("exec-timeout") {
+		return nil, opts, fmt.Errorf("--exec-timeout limits per-message commands and cannot be used with --exec-persistent")
+	}
+	line, _ := cmd.Flags().GetString("exec")
+	if line == "" {
+		if opts.persistent {
+			return nil, opts, fmt.Errorf("--exec-persistent needs a command given with --exec")
+		}
+		return nil, opts, nil
+	}
+	args, err := splitCommand(line)
+	return args, opts, err
 }
 
 func runConnect(cmd *cobra.Command, args []string) error {
-	path, _ := cmd.Flags().GetString("path")
+	path := stringSetting(cmd, "path", "connect.path")
+	opts, err := connectDialOptions(cmd)
+	if err != nil {
+		return err
+	}
+	pipeline, err := connectPipeline(cmd)
+	if err != nil {
+		return err
+	}
+	codecs, forced, err := connectCodecs(cmd)
+	if err != nil {
+		return err
+	}
+	execArgs, execOpts, err := connectExecOptions(cmd)
+	if err != nil {
+		return err
+	}
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	if execArgs != nil {
+		out, errOut = &lockedWriter{w: out}, &lockedWriter{w: errOut}
+	}
+	printer, err := newMessagePrinter(cmd, out, errOut, pipeline)
+	if err != nil {
+		return err
+	}
+	sends, _ := cmd.Flags().GetStringArray("send")
+	pipe, _ := cmd.Flags().GetBool("pipe")
+	framingName, _ := cmd.Flags().GetString("pipe-framing")
+	framing, err := newPipeFraming(framingName, opts.MaxMessageSize)
+	if err != nil {
+		return err
+	}
 	serverURL := viper.GetString("server")
 	token := viper.GetString("token")
 
-	fmt.Fprintf(cmd.OutOrStdout(), "Connecting to %s%s\n", serverURL, path)
+	if !pipe {
+		fmt.Fprintf(out, "Connecting to %s%s\n", serverURL, path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	defer signal.Stop(interrupt)
+	sess, err := svc.Connect(ctx, serverURL, path, token, opts)
+	if err != nil {
+		fmt.Fprintf(errOut, "connection error: %v\n", err)
+		return dialError(err)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	defer reportDropped(cmd, sess)
 
-	fmt.Fprintln(cmd.OutOrStdout(), "Connected. Press Ctrl+C to disconnect.")
+	if pipe {
+		return runPipe(ctx, cmd, sess, framing)
+	}
 
-	connDone := make(chan error, 1)
-	go func() {
-		connDone <- svc.Connect(ctx, serverURL, path, token, func(msgType int, data []byte) {
-			switch msgType {
-			case websocket.TextMessage:
-				fmt.Fprintf(cmd.OutOrStdout(), "< %s\n", data)
-			case websocket.BinaryMessage:
-				fmt.Fprintf(cmd.OutOrStdout(), "< [binary %d bytes]\n", len(data))
+	var persistent *persistentExec
+	var childExited <-chan struct{}
+	if execArgs != nil && !execOpts.persistent {
+		perMessage := newMessageExec(execArgs, execOpts.concurrency, opts.QueueSize, execOpts.timeout, out, errOut)
+		printer.exec = perMessage
+		defer perMessage.wait()
+	}
+	defer func() {
+		if persistent != nil {
+			if err := persistent.stop(opts.CloseTimeout); err != nil && childExited != nil {
+				fmt.Fprintf(errOut, "exec: %s: %v\n", execArgs[0], err)
 			}
-		})
+		}
 	}()
 
-	select {
-	case err := <-connDone:
-		if err != nil {
-			fmt.Fprintf(cmd.ErrOrStderr(), "connection error: %v\n", err)
-			cancel()
-			return err
+	// failure is returned once a session ended by the CLI has closed.
+	var failure error
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return failure
+			}
+			switch e := ev.(type) {
+			case ports.Opened:
+				fmt.Fprintln(out, "Connected. Press Ctrl+C to disconnect.")
+				if e.Subprotocol != "" {
+					fmt.Fprintf(out, "Subprotocol: %s\n", e.Subprotocol)
+				}
+				if len(e.Extensions) > 0 {
+					fmt.Fprintf(out, "Extensions: %s\n", strings.Join(e.Extensions, ", "))
+				}
+				printer.codec = chooseCodec(codecs, forced, e.Subprotocol)
+				if printer.codec != codec.JSON {
+					fmt.Fprintf(out, "Codec: %s\n", printer.codec.Name())
+				}
+				if err := sendAll(ctx, sess, printer.codec, sends); err != nil {
+					fmt.Fprintf(errOut, "send error: %v\n", err)
+					_ = sess.Close(ports.CloseNormalClosure, "")
+					return err
+				}
+				if execOpts.persistent {
+					if persistent, err = startPersistentExec(ctx, execArgs, opts.QueueSize, sess, printer.codec, errOut); err != nil {
+						fmt.Fprintf(errOut, "%v\n", err)
+						_ = sess.Close(ports.CloseNormalClosure, "")
+						return err
+					}
+					printer.exec = persistent
+					childExited = persistent.exited
+				}
+			case ports.Message:
+				printer.message(e)
+			case ports.Stream:
+				printer.stream(e)
+			case ports.Closed:
+				if e.Local {
+					fmt.Fprintf(out, "Connection closed: %s\n", describeClose(e))
+					return failure
+				}
+				return serverClosed(errOut, e)
+			case ports.Error:
+				fmt.Fprintf(errOut, "connection error: %v\n", e.Err)
+				return e.Err
+			}
+		case <-childExited:
+			childExited = nil
+			if persistent.err != nil {
+				fmt.Fprintf(errOut, "exec: %s: %v\n", execArgs[0], persistent.err)
+				failure = persistent.err
+			}
+			fmt.Fprintln(out, "Command exited. Closing connection...")
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			fmt.Fprintln(out, "\nInterrupted. Closing connection...")
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
 		}
-		fmt.Fprintln(cmd.OutOrStdout(), "Connection closed by server.")
-		cancel()
-	case <-interrupt:
-		fmt.Fprintln(cmd.OutOrStdout(), "\nInterrupted. Closing connection...")
-		cancel()
-		<-connDone
 	}
+}
 
-	return nil
+// reportDropped tells the user how many messages the overflow policy discarded.
+func reportDropped(cmd *cobra.Command, sess ports.Session) {
+	if stats := sess.Stats(); stats.Dropped > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Dropped %d of %d messages because output could not keep up.\n", stats.Dropped, stats.Received)
+	}
 }
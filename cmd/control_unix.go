@@ -0,0 +1,156 @@
+//go:build unix
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A running "ravenpair share" listens on a unix socket, readable by its user
+// only, through which grant and revoke change roles while the session is
+// live. Each connection carries one JSON controlRequest and its controlReply.
+
+// controlTimeout bounds a grant or revoke call.
+const controlTimeout = 5 * time.Second
+
+type controlRequest struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+type controlReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// controlCall is a request received on the socket, waiting for its reply.
+type controlCall struct {
+	req   controlRequest
+	reply chan error
+}
+
+// controlDir returns the directory that holds the control sockets, creating
+// it if needed.
+func controlDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = os.TempDir()
+	}
+	dir := filepath.Join(base, fmt.Sprintf("ravenpair-%d", os.Getuid()))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func controlSocketName(pairID string) string {
+	return "share-" + url.PathEscape(pairID) + ".sock"
+}
+
+// listenControl opens the control socket of the share of pairID. It fails if
+// another share of the same pair is running; a socket left behind by one that
+// crashed is replaced.
+func listenControl(pairID string) (net.Listener, error) {
+	dir, err := controlDir()
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+	path := filepath.Join(dir, controlSocketName(pairID))
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("pair %s is already being shared from this machine", pairID)
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+	return ln, nil
+}
+
+// serveControl passes the requests received on ln to calls until ln is
+// closed.
+func serveControl(ctx context.Context, ln net.Listener, calls chan<- controlCall) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+			var req controlRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				return
+			}
+			call := controlCall{req: req, reply: make(chan error, 1)}
+			var reply controlReply
+			select {
+			case calls <- call:
+				if err := <-call.reply; err != nil {
+					reply.Error = err.Error()
+				}
+			case <-ctx.Done():
+				reply.Error = "the share is ending"
+			}
+			_ = json.NewEncoder(conn).Encode(reply)
+		}()
+	}
+}
+
+// callControl sends req to the share of pairID, or to the only share running
+// when pairID is empty.
+func callControl(pairID string, req controlRequest) error {
+	dir, err := controlDir()
+	if err != nil {
+		return err
+	}
+	var path string
+	if pairID != "" {
+		path = filepath.Join(dir, controlSocketName(pairID))
+	} else {
+		matches, _ := filepath.Glob(filepath.Join(dir, "share-*.sock"))
+		switch len(matches) {
+		case 0:
+			return errors.New("no share is running on this machine")
+		case 1:
+			path = matches[0]
+		default:
+			pairs := make([]string, len(matches))
+			for i, m := range matches {
+				name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), "share-"), ".sock")
+				pairs[i], _ = url.PathUnescape(name)
+			}
+			return fmt.Errorf("several pairs are being shared (%s): use --pair", strings.Join(pairs, ", "))
+		}
+	}
+
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		if pairID != "" {
+			return fmt.Errorf("pair %s is not being shared from this machine", pairID)
+		}
+		return fmt.Errorf("contacting the share: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("contacting the share: %w", err)
+	}
+	var reply controlReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return fmt.Errorf("contacting the share: %w", err)
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/app"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// addSessionFlags declares the flags of the commands that join a pair
+// session.
+func addSessionFlags(c *cobra.Command) {
+	c.Flags().String("path", "/ws", "WebSocket endpoint path")
+	c.Flags().Duration("close-timeout", 5*time.Second, "how long to wait for the server to acknowledge a close")
+	c.Flags().String("max-message-size", "64MiB", "largest message accepted from the server; 0 for no limit")
+}
+
+// pairPath returns the endpoint path of a pair session.
+func pairPath(cmd *cobra.Command, pairID string) string {
+	return app.PairPath(stringSetting(cmd, "path", "connect.path"), pairID)
+}
+
+// dialSession connects a command declared with addSessionFlags to the
+// session at path, reporting why it could not.
+func dialSession(ctx context.Context, cmd *cobra.Command, path string) (ports.Session, error) {
+	closeTimeout, _ := cmd.Flags().GetDuration("close-timeout")
+	maxMessageSize, err := byteSizeFlag(cmd, "max-message-size")
+	if err != nil {
+		return nil, err
+	}
+	opts := ports.DialOptions{CloseTimeout: closeTimeout, MaxMessageSize: maxMessageSize}
+	sess, err := svc.Connect(ctx, viper.GetString("server"), path, viper.GetString("token"), opts)
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "connection error: %v\n", err)
+		return nil, dialError(err)
+	}
+	return sess, nil
+}
+
+// sendEvent sends an event of the given type to the pair session.
+func sendEvent(ctx context.Context, sess ports.Session, eventType, pairID string, payload interface{}) error {
+	data, err := protocol.Encode(eventType, pairID, payload)
+	if err != nil {
+		return err
+	}
+	return sess.Send(ctx, ports.TextMessage, data)
+}
+
+// awaitOpened waits for the session to open, reporting why it did not.
+func awaitOpened(cmd *cobra.Command, sess ports.Session) error {
+	for ev := range sess.Events() {
+		switch e := ev.(type) {
+		case ports.Opened:
+			return nil
+		case ports.Closed:
+			if err := closeError(e); err != nil {
+				return err
+			}
+			return fmt.Errorf("the server closed the connection: %s", describeClose(e))
+		case ports.Error:
+			fmt.Fprintf(cmd.ErrOrStderr(), "connection error: %v\n", e.Err)
+			return e.Err
+		}
+	}
+	return errors.New("the connection ended before it opened")
+}
+
+// sessionEnded reports the Closed or Error event that ends the event loop of
+// a command, returning result after a close this side started and the reason
+// the session ended otherwise.
+func sessionEnded(cmd *cobra.Command, out io.Writer, ev ports.Event, result error) error {
+	switch e := ev.(type) {
+	case ports.Closed:
+		if e.Local {
+			fmt.Fprintf(out, "Connection closed: %s\n", describeClose(e))
+			return result
+		}
+		return serverClosed(cmd.ErrOrStderr(), e)
+	case ports.Error:
+		fmt.Fprintf(cmd.ErrOrStderr(), "connection error: %v\n", e.Err)
+		return e.Err
+	}
+	return result
+}
+
+// interruptSession closes the session after Ctrl+C; the event loop then ends
+// with its Closed event.
+func interruptSession(out io.Writer, sess ports.Session) error {
+	fmt.Fprintln(out, "\nInterrupted. Closing connection...")
+	return sess.Close(ports.CloseNormalClosure, "")
+}
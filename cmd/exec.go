@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ravenpair/cli/internal/codec"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// execMessage is a received message handed to an --exec command. Seq counts
+// the messages received in the session, from one. Decoded marks a binary
+// message whose Data is the JSON document the session's codec decoded.
+type execMessage struct {
+	Seq     uint64
+	Type    int
+	Data    []byte
+	Decoded bool
+}
+
+// textual reports whether the Data of m is text rather than raw bytes.
+func (m execMessage) textual() bool {
+	return m.Type == ports.TextMessage || m.Decoded
+}
+
+// typeName names the WebSocket message type of m.
+func (m execMessage) typeName() string {
+	if m.Type == ports.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
+
+// eventType returns the type of the event envelope in m, if it holds one.
+func (m execMessage) eventType() string {
+	if !m.textual() {
+		return ""
+	}
+	e, err := protocol.Decode(m.Data)
+	if err != nil {
+		return ""
+	}
+	return e.Type
+}
+
+// splitCommand splits an --exec command line into its arguments. Words are
+// separated by spaces and may be quoted with single or double quotes; a
+// backslash escapes the next character outside single quotes. The command is
+// run directly, not through a shell.
+func splitCommand(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", line)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty --exec command")
+	}
+	return args, nil
+}
+
+// lockedWriter serialises writes from concurrent commands and the event loop.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// messageExec runs a command for every message, with the payload on stdin
+// and its metadata in RAVENPAIR_MESSAGE_* environment variables. At most
+// concurrency commands run at once; up to backlog further messages wait for
+// a free slot, in order, and any more are dropped with a warning, so that
+// handle never holds up the event loop.
+type messageExec struct {
+	args        []string
+	timeout     time.Duration
+	out, errOut io.Writer
+	slots       chan struct{}
+	pending     chan execMessage
+	dispatched  chan struct{}
+	wg          sync.WaitGroup
+}
+
+func newMessageExec(args []string, concurrency, backlog int, timeout time.Duration, out, errOut io.Writer) *messageExec {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if backlog < 1 {
+		backlog = 256 // as the session's queue does by default
+	}
+	x := &messageExec{
+		args:       args,
+		timeout:    timeout,
+		out:        out,
+		errOut:     errOut,
+		slots:      make(chan struct{}, concurrency),
+		pending:    make(chan execMessage, backlog),
+		dispatched: make(chan struct{}),
+	}
+	go x.dispatch()
+	return x
+}
+
+func (x *messageExec) handle(m execMessage) {
+	select {
+	case x.pending <- m:
+	default:
+		fmt.Fprintf(x.errOut, "exec: message %d dropped: %d messages are already waiting for a command\n", m.Seq, cap(x.pending))
+	}
+}
+
+// dispatch starts the command for each pending message as slots free up.
+func (x *messageExec) dispatch() {
+	defer close(x.dispatched)
+	for m := range x.pending {
+		x.slots <- struct{}{}
+		x.wg.Add(1)
+		go func() {
+			defer func() {
+				<-x.slots
+				x.wg.Done()
+			}()
+			x.run(m)
+		}()
+	}
+}
+
+// run runs the command for m. Its output is collected and written in one go
+// so that the output of concurrent commands does not interleave.
+func (x *messageExec) run(m execMessage) {
+	ctx := context.Background()
+	if x.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, x.timeout)
+		defer cancel()
+	}
+	c := exec.CommandContext(ctx, x.args[0], x.args[1:]...)
+	c.Stdin = bytes.NewReader(m.Data)
+	c.Env = append(os.Environ(),
+		"RAVENPAIR_MESSAGE_TYPE="+m.typeName(),
+		"RAVENPAIR_MESSAGE_SIZE="+strconv.Itoa(len(m.Data)),
+		"RAVENPAIR_MESSAGE_SEQ="+strconv.FormatUint(m.Seq, 10),
+		"RAVENPAIR_EVENT_TYPE="+m.eventType(),
+	)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	err := c.Run()
+
+	_, _ = x.out.Write(stdout.Bytes())
+	_, _ = x.errOut.Write(stderr.Bytes())
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		fmt.Fprintf(x.errOut, "exec: message %d: %s timed out after %s\n", m.Seq, x.args[0], x.timeout)
+	case err != nil:
+		fmt.Fprintf(x.errOut, "exec: message %d: %s: %v\n", m.Seq, x.args[0], err)
+	}
+}
+
+// wait runs the commands for the messages still pending and waits for them
+// all to finish. handle must not be called afterwards.
+func (x *messageExec) wait() {
+	close(x.pending)
+	<-x.dispatched
+	x.wg.Wait()
+}
+
+// ndjsonMessage is the line a persistent --exec command reads for each
+// message. Payload holds JSON messages as they are, decoded ones included,
+// other text messages as a string and binary messages as a base64 string.
+type ndjsonMessage struct {
+	Seq      uint64          `json:"seq"`
+	Type     string          `json:"type"`
+	Size     int             `json:"size"`
+	Encoding string          `json:"encoding,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func newNDJSONMessage(m execMessage) ndjsonMessage {
+	line := ndjsonMessage{Seq: m.Seq, Type: m.typeName(), Size: len(m.Data)}
+	switch {
+	case m.textual() && json.Valid(m.Data):
+		var compact bytes.Buffer
+		_ = json.Compact(&compact, m.Data)
+		line.Payload = compact.Bytes()
+	case m.textual():
+		line.Payload, _ = json.Marshal(string(m.Data))
+	default:
+		line.Encoding = "base64"
+		line.Payload, _ = json.Marshal(m.Data)
+	}
+	return line
+}
+
+// persistentExec feeds every message to one long-lived command as a line of
+// NDJSON and sends each line the command prints back over the session,
+// encoded with the session's codec when it is a binary one. Messages are
+// written from a goroutine of their own, so that a command slow to read its
+// stdin does not hold up the session; up to backlog of them wait, and
+// further ones are dropped.
+type persistentExec struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	errOut io.Writer
+	lines  chan execMessage
+	// written is closed once every queued message has been written, or
+	// writing failed.
+	written chan struct{}
+	// exited is closed once the command has exited and its output has been
+	// relayed; err then holds its result.
+	exited chan struct{}
+	err    error
+}
+
+func startPersistentExec(ctx context.Context, args []string, backlog int, sess ports.Session, c codec.Codec, errOut io.Writer) (*persistentExec, error) {
+	if backlog < 1 {
+		backlog = 256 // as the session's queue does by default
+	}
+	x := &persistentExec{
+		cmd:     exec.Command(args[0], args[1:]...),
+		errOut:  errOut,
+		lines:   make(chan execMessage, backlog),
+		written: make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	x.cmd.Stderr = errOut
+	stdin, err := x.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := x.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := x.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+	x.stdin = stdin
+
+	go x.write(json.NewEncoder(stdin))
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 64<<20)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			// Text codecs send lines as they are, so the command need not
+			// print JSON; binary codecs need JSON to encode.
+			var err error
+			if c.Binary() {
+				err = sendAll(ctx, sess, c, []string{string(line)})
+			} else {
+				err = sess.Send(ctx, ports.TextMessage, bytes.Clone(line))
+			}
+			if err != nil {
+				fmt.Fprintf(errOut, "exec: sending %q: %v\n", line, err)
+			}
+		}
+		_, _ = io.Copy(io.Discard, stdout)
+		x.err = x.cmd.Wait()
+		close(x.exited)
+	}()
+	return x, nil
+}
+
+func (x *persistentExec) handle(m execMessage) {
+	select {
+	case x.lines <- m:
+	default:
+		fmt.Fprintf(x.errOut, "exec: message %d dropped: %d messages are already waiting for the command\n", m.Seq, cap(x.lines))
+	}
+}
+
+// write writes the queued messages to the command's stdin until the queue is
+// closed. Once a write fails, the rest are discarded.
+func (x *persistentExec) write(enc *json.Encoder) {
+	defer close(x.written)
+	for m := range x.lines {
+		if err := enc.Encode(newNDJSONMessage(m)); err != nil {
+			fmt.Fprintf(x.errOut, "exec: writing message %d: %v\n", m.Seq, err)
+			break
+		}
+	}
+	for range x.lines {
+	}
+}
+
+// stop lets the command read the messages still queued, closes its stdin and
+// waits for it to exit. The command is killed when all that takes longer
+// than timeout. handle must not be called afterwards.
+func (x *persistentExec) stop(timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	close(x.lines)
+	select {
+	case <-x.written:
+	case <-x.exited:
+	case <-deadline.C:
+		_ = x.cmd.Process.Kill()
+	}
+	_ = x.stdin.Close()
+	select {
+	case <-x.exited:
+	case <-deadline.C:
+		_ = x.cmd.Process.Kill()
+		<-x.exited
+	}
+	return x.err
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+// Process exit codes. Besides 0 and 1 they let scripts tell apart why a
+// WebSocket session ended; they are documented in "connect --help".
+const (
+	exitError           = 1
+	exitGoingAway       = 3
+	exitPolicyViolation = 4
+	exitAuthFailed      = 5
+	exitAbnormalClose   = 6
+)
+
+// Close codes RavenPair servers use for authentication and authorisation
+// failures, mirroring HTTP 401 and 403.
+const (
+	closeUnauthorized = 4401
+	closeForbidden    = 4403
+)
+
+// exitCodeError makes Execute exit with code instead of 1.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// exitCode returns the process exit code for an error returned by a command.
+func exitCode(err error) int {
+	var ec *exitCodeError
+	if errors.As(err, &ec) {
+		return ec.code
+	}
+	return exitError
+}
+
+// closeError turns a close initiated by the server into the error runConnect
+// returns, or nil when the server closed normally.
+func closeError(e ports.Closed) error {
+	var code int
+	switch e.Code {
+	case ports.CloseNormalClosure, ports.CloseNoStatus:
+		return nil
+	case ports.CloseGoingAway:
+		code = exitGoingAway
+	case ports.ClosePolicyViolation:
+		code = exitPolicyViolation
+	case closeUnauthorized, closeForbidden:
+		code = exitAuthFailed
+	default:
+		code = exitAbnormalClose
+	}
+	return &exitCodeError{code: code, err: fmt.Errorf("connection closed by server: %s", describeClose(e))}
+}
+
+// serverClosed ends a command whose session the server closed. Execute
+// prints the error of an abnormal close, so only a normal one is reported
+// here, on errOut.
+func serverClosed(errOut io.Writer, e ports.Closed) error {
+	err := closeError(e)
+	if err == nil {
+		fmt.Fprintf(errOut, "Connection closed by server: %s\n", describeClose(e))
+	}
+	return err
+}
+
+// dialError attaches an exit code to a failed opening handshake.
+func dialError(err error) error {
+	var he *ports.HandshakeError
+	if errors.As(err, &he) && (he.StatusCode == http.StatusUnauthorized || he.StatusCode == http.StatusForbidden) {
+		return &exitCodeError{code: exitAuthFailed, err: err}
+	}
+	return err
+}
+
+var closeCodeNames = map[int]string{
+	ports.CloseNormalClosure:   "normal closure",
+	ports.CloseGoingAway:       "going away",
+	1002:                       "protocol error",
+	1003:                       "unsupported data",
+	ports.CloseNoStatus:        "no status",
+	ports.CloseAbnormalClosure: "abnormal closure",
+	1007:                       "invalid payload",
+	ports.ClosePolicyViolation: "policy violation",
+	1009:                       "message too big",
+	1010:                       "mandatory extension",
+	1011:                       "internal server error",
+	1012:                       "service restart",
+	1013:                       "try again later",
+	closeUnauthorized:          "unauthorized",
+	closeForbidden:             "forbidden",
+}
+
+// describeClose formats a close code with its name and the peer's reason.
+func describeClose(e ports.Closed) string {
+	s := fmt.Sprintf("%d", e.Code)
+	if name, ok := closeCodeNames[e.Code]; ok {
+		s += " " + name
+	}
+	if e.Reason != "" {
+		s += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	return s
+}
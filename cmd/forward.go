@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+	"github.com/ravenpair/cli/internal/tunnel"
+)
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward [L:|R:][bind_address:]port:host:hostport ...",
+	Short: "Forward TCP ports through a pair session",
+	Long: `Tunnel TCP connections through a pair session, as ssh -L and -R do:
+
+  ravenpair forward --pair p1 L:8080:localhost:3000
+      connections to port 8080 on your machine reach localhost:3000 as seen
+      from your partner's machine
+  ravenpair forward --pair p1 -R 9000:localhost:3000
+      connections to port 9000 on your partner's machine reach
+      localhost:3000 as seen from yours
+
+Ports are bound on localhost unless a bind address is given. Each connection
+is a channel multiplexed over the session's WebSocket.
+
+Your partner approves every forward by running
+  ravenpair forward --serve --pair p1
+which asks them about each request before anything listens or connects on
+their machine. The side whose services are exposed, your partner's for L:
+forwards and yours for R: forwards, only allows targets in the forward.allow
+list of its config, when it has one:
+
+  forward:
+    allow: ["localhost:3000", "127.0.0.1:*"]
+
+Both sides print a tunnel's connection and byte counters whenever a
+connection through it ends, and a summary when they stop.`,
+	RunE: runForward,
+}
+
+func init() {
+	rootCmd.AddCommand(forwardCmd)
+	forwardCmd.Flags().String("pair", "", "pair session to forward through")
+	forwardCmd.Flags().BoolP("reverse", "R", false, "forward ports without an L: or R: prefix from the partner's machine to yours")
+	forwardCmd.Flags().Bool("serve", false, "approve and serve the forwards others in the pair ask for")
+	addSessionFlags(forwardCmd)
+}
+
+// randomID returns a random, non-zero 32-bit identifier.
+func randomID() uint32 {
+	var b [4]byte
+	for {
+		_, _ = rand.Read(b[:])
+		if id := binary.BigEndian.Uint32(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+// forwarder runs one side of the forwards of a pair session: the one asking
+// for them, or the one serving them with --serve.
+type forwarder struct {
+	ctx    context.Context
+	sess   ports.Session
+	pairID string
+	node   uint32
+	mux    *tunnel.Mux
+	out    io.Writer
+	allow  []string
+
+	mu sync.Mutex
+	// names describes each tunnel in the counters printed.
+	names map[uint32]string
+
+	// requests are the forwards asked for, by tunnel.
+	requests map[uint32]*forwardRequest
+	// served holds the tunnels served for others, with who asked for each.
+	served map[uint32]tunnel.Peer
+}
+
+type forwardRequest struct {
+	spec tunnel.Spec
+	// server is the one that accepted the forward; its node is zero until
+	// then.
+	server   tunnel.Peer
+	answered bool
+}
+
+// forwardAsk is a request waiting for the user's approval.
+type forwardAsk struct {
+	user string
+	peer tunnel.Peer
+	req  protocol.ForwardRequestPayload
+}
+
+func runForward(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "pair", "forward.pair")
+	if pairID == "" {
+		return errors.New("a pair is required: use --pair")
+	}
+	serve, _ := cmd.Flags().GetBool("serve")
+	reverse, _ := cmd.Flags().GetBool("reverse")
+	if serve == (len(args) > 0) {
+		return errors.New("give the ports to forward, or --serve to approve the forwards of others")
+	}
+	allow := viper.GetStringSlice("forward.allow")
+	var specs []tunnel.Spec
+	for _, arg := range args {
+		spec, err := tunnel.ParseSpec(arg, reverse)
+		if err != nil {
+			return err
+		}
+		if spec.Reverse && !tunnel.Allowed(allow, spec.Target) {
+			return fmt.Errorf("%s is not in the forward.allow list of your config", spec.Target)
+		}
+		specs = append(specs, spec)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+
+	f := &forwarder{
+		ctx:      ctx,
+		sess:     sess,
+		pairID:   pairID,
+		node:     randomID(),
+		out:      &lockedWriter{w: cmd.OutOrStdout()},
+		allow:    allow,
+		names:    make(map[uint32]string),
+		requests: make(map[uint32]*forwardRequest),
+		served:   make(map[uint32]tunnel.Peer),
+	}
+	f.mux = tunnel.NewMux(ctx, sess, pairID, f.node)
+	f.mux.OnClose = f.connectionClosed
+
+	var asks chan *question
+	var answers <-chan *question
+	if serve {
+		asks = make(chan *question, 16)
+		answers = promptUser(cmd.InOrStdin(), f.out, asks)
+		fmt.Fprintf(f.out, "Serving forwards for pair %s. Press Ctrl+C to stop.\n", pairID)
+	} else {
+		for _, spec := range specs {
+			id := randomID()
+			f.requests[id] = &forwardRequest{spec: spec}
+			f.send(protocol.TypeForwardRequest, protocol.ForwardRequestPayload{Tunnel: id, Node: f.node, Reverse: spec.Reverse, Listen: spec.Listen, Target: spec.Target})
+		}
+		fmt.Fprintf(f.out, "Waiting for the pair to approve %d forward(s) with \"ravenpair forward --serve\"...\n", len(specs))
+	}
+
+	err = f.loop(cmd, asks, answers)
+	f.stopAll()
+	return err
+}
+
+func (f *forwarder) loop(cmd *cobra.Command, asks chan<- *question, answers <-chan *question) error {
+	var failure error
+	interrupted := f.ctx.Done()
+	for {
+		select {
+		case ev, ok := <-f.sess.Events():
+			if !ok {
+				return failure
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if f.mux.Handle(e) || e.Type != ports.TextMessage {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil {
+					continue
+				}
+				if err := f.handle(event, asks); err != nil && failure == nil {
+					failure = err
+					fmt.Fprintf(cmd.ErrOrStderr(), "forward: %v\n", err)
+					if err := f.sess.Close(ports.CloseNormalClosure, ""); err != nil {
+						return err
+					}
+				}
+			case ports.Closed, ports.Error:
+				return sessionEnded(cmd, f.out, ev, failure)
+			}
+		case q := <-answers:
+			f.answer(q.about.(*forwardAsk), q.yes)
+		case <-interrupted:
+			interrupted = nil
+			f.stopAll()
+			if err := interruptSession(f.out, f.sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle processes a forwarding event. It returns an error when none of the
+// forwards asked for can be made.
+func (f *forwarder) handle(e protocol.Envelope, asks chan<- *question) error {
+	switch e.Type {
+	case protocol.TypeForwardRequest:
+		var p protocol.ForwardRequestPayload
+		if asks == nil || e.DecodePayload(&p) != nil {
+			return nil
+		}
+		if _, ok := f.served[p.Tunnel]; ok {
+			f.send(protocol.TypeForwardReply, protocol.ForwardReplyPayload{Tunnel: p.Tunnel, Node: f.node, Reason: "the tunnel is already in use"})
+			return nil
+		}
+		if !p.Reverse && !tunnel.Allowed(f.allow, p.Target) {
+			f.send(protocol.TypeForwardReply, protocol.ForwardReplyPayload{Tunnel: p.Tunnel, Node: f.node, Reason: "the target is not in the allowlist"})
+			fmt.Fprintf(f.out, "Refused %s's forward to %s: not in the forward.allow list.\n", senderName(e.Sender), p.Target)
+			return nil
+		}
+		ask := &forwardAsk{user: senderName(e.Sender), peer: tunnel.Peer{Sender: e.Sender, Node: p.Node}, req: p}
+		select {
+		case asks <- &question{text: ask.question(), about: ask}:
+		default:
+			f.send(protocol.TypeForwardReply, protocol.ForwardReplyPayload{Tunnel: p.Tunnel, Node: f.node, Reason: "too many pending requests"})
+		}
+
+	case protocol.TypeForwardReply:
+		var p protocol.ForwardReplyPayload
+		if e.DecodePayload(&p) != nil {
+			return nil
+		}
+		r, ok := f.requests[p.Tunnel]
+		if !ok {
+			return nil
+		}
+		if r.answered {
+			// Another server accepted too late; only one may serve.
+			if p.Accepted && p.Node != r.server.Node {
+				f.send(protocol.TypeForwardCancel, protocol.ForwardCancelPayload{Tunnel: p.Tunnel, Node: p.Node})
+			}
+			return nil
+		}
+		r.answered = true
+		if !p.Accepted {
+			fmt.Fprintf(f.out, "%s refused %s: %s\n", senderName(e.Sender), r.spec, p.Reason)
+			delete(f.requests, p.Tunnel)
+			return f.checkActive()
+		}
+		r.server = tunnel.Peer{Sender: e.Sender, Node: p.Node}
+		var name string
+		if r.spec.Reverse {
+			f.mux.Dial(p.Tunnel, r.spec.Target, r.server)
+			name = fmt.Sprintf("%s on %s's machine -> %s", r.spec.Listen, senderName(e.Sender), r.spec.Target)
+		} else {
+			addr, err := f.mux.Listen(p.Tunnel, r.spec.Listen, r.server)
+			if err != nil {
+				fmt.Fprintf(f.out, "Cannot listen on %s: %v\n", r.spec.Listen, err)
+				f.send(protocol.TypeForwardCancel, protocol.ForwardCancelPayload{Tunnel: p.Tunnel, Node: p.Node})
+				delete(f.requests, p.Tunnel)
+				return f.checkActive()
+			}
+			name = fmt.Sprintf("%s -> %s on %s's machine", addr, r.spec.Target, senderName(e.Sender))
+		}
+		f.setName(p.Tunnel, name)
+		fmt.Fprintf(f.out, "Forwarding %s\n", name)
+
+	case protocol.TypeForwardCancel:
+		var p protocol.ForwardCancelPayload
+		if e.DecodePayload(&p) != nil {
+			return nil
+		}
+		if r, ok := f.requests[p.Tunnel]; ok && r.server.Node != 0 && r.server == (tunnel.Peer{Sender: e.Sender, Node: p.Node}) {
+			fmt.Fprintf(f.out, "%s stopped serving %s\n", senderName(e.Sender), r.spec)
+			f.printStats(p.Tunnel, f.mux.Remove(p.Tunnel))
+			delete(f.requests, p.Tunnel)
+			return f.checkActive()
+		}
+		if peer, ok := f.served[p.Tunnel]; ok && peer.Sender == e.Sender && (p.Node == 0 || p.Node == f.node) {
+			fmt.Fprintf(f.out, "%s stopped forwarding through %s\n", senderName(e.Sender), f.name(p.Tunnel))
+			f.printStats(p.Tunnel, f.mux.Remove(p.Tunnel))
+			delete(f.served, p.Tunnel)
+		}
+	}
+	return nil
+}
+
+// question asks the user to approve the request.
+func (ask *forwardAsk) question() string {
+	p := ask.req
+	if p.Reverse {
+		return fmt.Sprintf("%s asks to listen on %s on this machine and forward to %s on theirs. Allow?", ask.user, p.Listen, p.Target)
+	}
+	return fmt.Sprintf("%s asks to reach %s from this machine. Allow?", ask.user, p.Target)
+}
+
+// answer replies to a request the user approved or refused.
+func (f *forwarder) answer(ask *forwardAsk, ok bool) {
+	p := ask.req
+	reply := protocol.ForwardReplyPayload{Tunnel: p.Tunnel, Node: f.node, Accepted: ok}
+	if !ok {
+		fmt.Fprintln(f.out, "Refused.")
+		reply.Reason = "not approved"
+		f.send(protocol.TypeForwardReply, reply)
+		return
+	}
+	var name string
+	if p.Reverse {
+		addr, err := f.mux.Listen(p.Tunnel, p.Listen, ask.peer)
+		if err != nil {
+			reply.Accepted, reply.Reason = false, err.Error()
+			fmt.Fprintf(f.out, "Cannot listen on %s: %v\n", p.Listen, err)
+			f.send(protocol.TypeForwardReply, reply)
+			return
+		}
+		name = fmt.Sprintf("%s -> %s on %s's machine", addr, p.Target, ask.user)
+	} else {
+		f.mux.Dial(p.Tunnel, p.Target, ask.peer)
+		name = fmt.Sprintf("%s on %s's machine -> %s", p.Listen, ask.user, p.Target)
+	}
+	f.served[p.Tunnel] = ask.peer
+	f.setName(p.Tunnel, name)
+	f.send(protocol.TypeForwardReply, reply)
+	fmt.Fprintf(f.out, "Forwarding %s\n", name)
+}
+
+// checkActive fails once every forward asked for was refused or stopped.
+func (f *forwarder) checkActive() error {
+	if len(f.requests) > 0 {
+		return nil
+	}
+	return errors.New("no forward is left")
+}
+
+// stopAll ends every tunnel, telling the partner, and prints their counters.
+func (f *forwarder) stopAll() {
+	for id, r := range f.requests {
+		if r.server.Node != 0 {
+			f.send(protocol.TypeForwardCancel, protocol.ForwardCancelPayload{Tunnel: id, Node: r.server.Node})
+			f.printStats(id, f.mux.Remove(id))
+		}
+		delete(f.requests, id)
+	}
+	for id := range f.served {
+		f.send(protocol.TypeForwardCancel, protocol.ForwardCancelPayload{Tunnel: id, Node: f.node})
+		f.printStats(id, f.mux.Remove(id))
+		delete(f.served, id)
+	}
+}
+
+func (f *forwarder) send(eventType string, payload interface{}) {
+	_ = sendEvent(f.ctx, f.sess, eventType, f.pairID, payload)
+}
+
+func (f *forwarder) setName(id uint32, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.names[id] = name
+}
+
+func (f *forwarder) name(id uint32) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.names[id]
+}
+
+// connectionClosed reports the end of a connection through a tunnel.
+func (f *forwarder) connectionClosed(id uint32, err error) {
+	if err != nil {
+		fmt.Fprintf(f.out, "Connection through %s failed: %v\n", f.name(id), err)
+	}
+	f.printStats(id, f.mux.Stats(id))
+}
+
+func (f *forwarder) printStats(id uint32, s tunnel.Stats) {
+	fmt.Fprintf(f.out, "  %s: %d connection(s), %d active, %s sent, %s received\n",
+		f.name(id), s.Connections, s.Active, formatByteSize(s.Sent), formatByteSize(s.Received))
+}
+
+// senderName names the sender of an event.
+func senderName(sender string) string {
+	if sender == "" {
+		return "someone"
+	}
+	return sender
+}
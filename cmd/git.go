@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Share where your git working tree is with a pair",
+	Long: `Tell the pair which branch and commit you are on, and hand your uncommitted
+changes to a partner as a patch. These commands run the git binary in the
+current directory.`,
+}
+
+var gitShareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Publish your branch, commit and remote to a pair",
+	Long: `Publish the current branch, HEAD commit and remote URL of the repository in
+the current directory to the pair session, as an event "ravenpair connect"
+shows. Credentials in the remote URL are left out.
+
+With --diff, your uncommitted changes to tracked files (git diff HEAD) are
+shared too. The command then stays connected, handing the patch to anyone
+who runs
+  ravenpair git apply --from <your user> <event-id>
+until you press Ctrl+C.`,
+	Args: cobra.NoArgs,
+	RunE: runGitShare,
+}
+
+var gitApplyCmd = &cobra.Command{
+	Use:   "apply --from <user> <event-id>",
+	Short: "Apply the patch a partner shared",
+	Long: `Fetch the patch of a "ravenpair git share --diff" event from the partner
+still sharing it, show the patch, through $PAGER (less by default) on a
+terminal, and apply it to the working tree of the repository in the current
+directory once you confirm. The patch is only taken from the user --from
+names, who published the event; "ravenpair connect" shows the whole command
+line with the event.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runGitApply,
+}
+
+func init() {
+	rootCmd.AddCommand(gitCmd)
+	gitCmd.AddCommand(gitShareCmd)
+	gitCmd.AddCommand(gitApplyCmd)
+	for _, c := range []*cobra.Command{gitShareCmd, gitApplyCmd} {
+		c.Flags().String("pair", "", "pair session to use")
+		addSessionFlags(c)
+	}
+	gitShareCmd.Flags().Bool("diff", false, "share your uncommitted changes as a patch too")
+	gitApplyCmd.Flags().String("from", "", "user who shared the event")
+	gitApplyCmd.Flags().BoolP("yes", "y", false, "apply the patch without asking")
+	gitApplyCmd.Flags().Duration("timeout", 10*time.Second, "how long to wait for the partner to send the patch")
+}
+
+// runGit runs git in the current directory with stdin as its input and
+// returns its output.
+func runGit(ctx context.Context, stdin string, args ...string) (string, error) {
+	return runGitIn(ctx, "", stdin, args...)
+}
+
+// runGitIn is runGit in dir; an empty dir is the current directory.
+func runGitIn(ctx context.Context, dir, stdin string, args ...string) (string, error) {
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+	c.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("git is not installed: %w", err)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// gitState describes the working tree in the current directory.
+func gitState(ctx context.Context) (protocol.GitSharePayload, error) {
+	var s protocol.GitSharePayload
+	head, err := runGit(ctx, "", "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return s, err
+	}
+	s.Head = strings.TrimSpace(head)
+	branch, err := runGit(ctx, "", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return s, err
+	}
+	s.Branch = strings.TrimSpace(branch)
+	if subject, err := runGit(ctx, "", "log", "-1", "--format=%s"); err == nil {
+		s.Subject = strings.TrimSpace(subject)
+	}
+	remote := "origin"
+	if name, err := runGit(ctx, "", "config", "--get", "branch."+s.Branch+".remote"); err == nil && strings.TrimSpace(name) != "" {
+		remote = strings.TrimSpace(name)
+	}
+	if u, err := runGit(ctx, "", "remote", "get-url", remote); err == nil {
+		s.Remote = redactURL(strings.TrimSpace(u))
+	}
+	return s, nil
+}
+
+// redactURL drops the credentials of a remote URL; scp-like URLs such as
+// git@host:path are kept as they are.
+func redactURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+// diffStat counts the files, insertions and deletions of a patch.
+func diffStat(patch string) (files, insertions, deletions int) {
+	lines := bufio.NewScanner(strings.NewReader(patch))
+	lines.Buffer(nil, len(patch)+1)
+	// inHunks is set from the first hunk of a file on, where "--- " and
+	// "+++ " start changed lines rather than file names.
+	inHunks := false
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files++
+			inHunks = false
+		case strings.HasPrefix(line, "@@"):
+			inHunks = true
+		case !inHunks:
+		case strings.HasPrefix(line, "+"):
+			insertions++
+		case strings.HasPrefix(line, "-"):
+			deletions++
+		}
+	}
+	return files, insertions, deletions
+}
+
+func runGitShare(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "pair", "git.pair")
+	if pairID == "" {
+		return errors.New("a pair is required: use --pair")
+	}
+	withDiff, _ := cmd.Flags().GetBool("diff")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	state, err := gitState(ctx)
+	if err != nil {
+		return err
+	}
+	state.ID = fmt.Sprintf("%08x", randomID())
+	out := cmd.OutOrStdout()
+	var patch string
+	if withDiff {
+		if patch, err = runGit(ctx, "", "diff", "--binary", "HEAD"); err != nil {
+			return err
+		}
+		if patch == "" {
+			fmt.Fprintln(out, "There are no uncommitted changes to share.")
+		}
+		state.Files, state.Insertions, state.Deletions = diffStat(patch)
+	}
+
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+	if err := sendEvent(ctx, sess, protocol.TypeGitShare, pairID, state); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Shared %s at %s as event %s.\n", state.Branch, protocol.ShortHash(state.Head), state.ID)
+	if patch == "" {
+		if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(out, "Serving the patch of %d file(s) to \"ravenpair git apply --from <your user> %s\". Press Ctrl+C to stop.\n", state.Files, state.ID)
+	}
+
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if e.Type != ports.TextMessage {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil || event.Type != protocol.TypeGitRequest {
+					continue
+				}
+				var p protocol.GitRequestPayload
+				if event.DecodePayload(&p) != nil || p.ID != state.ID || patch == "" {
+					continue
+				}
+				reply := protocol.GitPatchPayload{ID: state.ID, To: p.Node, Branch: state.Branch, Head: state.Head, Patch: patch}
+				if err := sendEvent(ctx, sess, protocol.TypeGitPatch, pairID, reply); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "git share: %v\n", err)
+					continue
+				}
+				fmt.Fprintf(out, "Sent the patch to %s.\n", senderName(event.Sender))
+			case ports.Closed, ports.Error:
+				return sessionEnded(cmd, out, ev, nil)
+			}
+		case <-interrupted:
+			interrupted = nil
+			if err := interruptSession(out, sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func runGitApply(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "pair", "git.pair")
+	if pairID == "" {
+		return errors.New("a pair is required: use --pair")
+	}
+	sharer, _ := cmd.Flags().GetString("from")
+	if sharer == "" {
+		return errors.New("the user who shared the event is required: use --from")
+	}
+	id := args[0]
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Fail early outside a repository, before asking anyone for anything.
+	// The paths of the patch are relative to the top of the working tree.
+	toplevel, err := runGit(ctx, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	toplevel = strings.TrimSpace(toplevel)
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+	node := randomID()
+	if err := sendEvent(ctx, sess, protocol.TypeGitRequest, pairID, protocol.GitRequestPayload{ID: id, Node: node}); err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Asking the pair for the patch of event %s...\n", id)
+
+	var patch *protocol.GitPatchPayload
+	var failure error
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return failure
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if e.Type != ports.TextMessage || patch != nil {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil || event.Type != protocol.TypeGitPatch || event.Sender != sharer {
+					continue
+				}
+				var p protocol.GitPatchPayload
+				if event.DecodePayload(&p) != nil || p.ID != id || p.To != node {
+					continue
+				}
+				patch = &p
+				if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+					return err
+				}
+			case ports.Closed, ports.Error:
+				if c, ok := e.(ports.Closed); ok && c.Local && patch != nil {
+					return applyPatch(ctx, cmd, toplevel, sharer, *patch)
+				}
+				return sessionEnded(cmd, out, ev, failure)
+			}
+		case <-deadline.C:
+			failure = fmt.Errorf("%s did not send the patch of event %s within %s; it is only available while \"ravenpair git share --diff\" runs", sharer, id, timeout)
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			if err := interruptSession(out, sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applyPatch previews a partner's patch and applies it to the working tree
+// at toplevel once the user agrees.
+func applyPatch(ctx context.Context, cmd *cobra.Command, toplevel, from string, p protocol.GitPatchPayload) error {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Patch from %s, against %s at %s:\n", from, p.Branch, protocol.ShortHash(p.Head))
+	stat, err := runGitIn(ctx, toplevel, p.Patch, "apply", "--stat")
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, stat)
+	if head, err := runGit(ctx, "", "rev-parse", "--verify", "HEAD"); err == nil && strings.TrimSpace(head) != p.Head {
+		fmt.Fprintf(out, "Your HEAD is %s, not %s; the patch may not apply as intended.\n", protocol.ShortHash(strings.TrimSpace(head)), protocol.ShortHash(p.Head))
+	}
+	if _, err := runGitIn(ctx, toplevel, p.Patch, "apply", "--check"); err != nil {
+		return fmt.Errorf("the patch does not apply to your working tree: %w", err)
+	}
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		showPatch(ctx, cmd, p.Patch)
+		if !confirm(bufio.NewScanner(cmd.InOrStdin()), out, "Apply it to your working tree?") {
+			fmt.Fprintln(out, "Not applied.")
+			return nil
+		}
+	}
+	if _, err := runGitIn(ctx, toplevel, p.Patch, "apply"); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Applied the patch of event %s.\n", p.ID)
+	return nil
+}
+
+// showPatch prints a patch, through $PAGER, or less, when the output is a
+// terminal.
+func showPatch(ctx context.Context, cmd *cobra.Command, patch string) {
+	out := cmd.OutOrStdout()
+	if f, ok := out.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		pager := os.Getenv("PAGER")
+		if pager == "" {
+			pager = "less -FRX"
+		}
+		if args := strings.Fields(pager); len(args) > 0 {
+			c := exec.CommandContext(ctx, args[0], args[1:]...)
+			c.Stdin, c.Stdout, c.Stderr = strings.NewReader(patch), f, cmd.ErrOrStderr()
+			if c.Run() == nil {
+				return
+			}
+		}
+	}
+	fmt.Fprint(out, patch)
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+var grantCmd = &cobra.Command{
+	Use:   "grant <user> <write|driver|navigator|spectator>",
+	Short: "Change a participant's role in the terminal you are sharing",
+	Long: `Change the role of a participant in the terminal shared by a running
+"ravenpair share" on this machine. Only drivers may type into the shared
+terminal; "write" is short for driver. Everyone in the session is told about
+the change.
+
+--pair picks the share when several are running.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runGrant,
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke <user>",
+	Short: "Make a participant of the terminal you are sharing a spectator",
+	Long: `Take write access away from a participant of the terminal shared by a
+running "ravenpair share" on this machine, making them a spectator. Everyone
+in the session is told about the change.
+
+--pair picks the share when several are running.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRevoke,
+}
+
+func init() {
+	rootCmd.AddCommand(grantCmd)
+	rootCmd.AddCommand(revokeCmd)
+	grantCmd.Flags().String("pair", "", "pair whose share to change")
+	revokeCmd.Flags().String("pair", "", "pair whose share to change")
+}
+
+// parseGrant returns the role a grant argument stands for.
+func parseGrant(arg string) (string, error) {
+	if arg == "write" {
+		return protocol.RoleDriver, nil
+	}
+	if !protocol.ValidRole(arg) {
+		return "", fmt.Errorf("unknown role %q: use write, driver, navigator or spectator", arg)
+	}
+	return arg, nil
+}
+
+func runGrant(cmd *cobra.Command, args []string) error {
+	role, err := parseGrant(args[1])
+	if err != nil {
+		return err
+	}
+	return changeRole(cmd, args[0], role)
+}
+
+func runRevoke(cmd *cobra.Command, args []string) error {
+	return changeRole(cmd, args[0], protocol.RoleSpectator)
+}
+
+func changeRole(cmd *cobra.Command, user, role string) error {
+	pairID, _ := cmd.Flags().GetString("pair")
+	if err := callControl(pairID, controlRequest{User: user, Role: role}); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is now a %s.\n", user, role)
+	return nil
+}
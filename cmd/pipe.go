@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+// pipeFramings lists how --pipe delimits messages on stdin and stdout.
+var pipeFramings = []string{"lines", "length"}
+
+// pipeFraming reads and writes messages in one of the pipeFramings. With
+// "lines" each message is a line, sent as a text message; with "length" each
+// is preceded by its size as a 4-byte big-endian integer and sent as a binary
+// message.
+type pipeFraming struct {
+	length bool
+	// max bounds the size of a length-prefixed message; zero means no limit.
+	max int64
+}
+
+func newPipeFraming(name string, max int64) (pipeFraming, error) {
+	switch name {
+	case "", "lines":
+		return pipeFraming{max: max}, nil
+	case "length":
+		return pipeFraming{length: true, max: max}, nil
+	}
+	return pipeFraming{}, fmt.Errorf("unknown pipe framing %q: use lines or length", name)
+}
+
+// reader returns a function that reads the next message from r, returning
+// io.EOF once r is exhausted. Empty lines are skipped.
+func (f pipeFraming) reader(r io.Reader) func() (int, []byte, error) {
+	br := bufio.NewReader(r)
+	if f.length {
+		return func() (int, []byte, error) {
+			var size uint32
+			if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = fmt.Errorf("truncated length prefix: %w", err)
+				}
+				return 0, nil, err
+			}
+			if f.max > 0 && int64(size) > f.max {
+				return 0, nil, fmt.Errorf("message of %d bytes on stdin: %w", size, ports.ErrMessageTooBig)
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return 0, nil, fmt.Errorf("truncated message on stdin: %w", err)
+			}
+			return ports.BinaryMessage, data, nil
+		}
+	}
+	return func() (int, []byte, error) {
+		for {
+			line, err := br.ReadBytes('\n')
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if len(line) > 0 {
+				return ports.TextMessage, line, nil
+			}
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+}
+
+// write writes a received message to w.
+func (f pipeFraming) write(w io.Writer, data []byte) error {
+	if f.length {
+		if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+			return err
+		}
+		_, err := w.Write(data)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// runPipe relays a session between stdin and stdout: every message read from
+// stdin is sent, and every message received is written out unmodified. When
+// stdin ends, or on interrupt, the session is closed normally. Only errors are
+// reported, on stderr.
+func runPipe(ctx context.Context, cmd *cobra.Command, sess ports.Session, framing pipeFraming) error {
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+
+	// The relay from stdin ends on its own when stdin does; the result is
+	// either nil at end of input or the error that stopped it.
+	inputDone := make(chan error, 1)
+	go func() {
+		next := framing.reader(cmd.InOrStdin())
+		for {
+			msgType, data, err := next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				inputDone <- err
+				return
+			}
+			if err := sess.Send(ctx, msgType, data); err != nil {
+				inputDone <- err
+				return
+			}
+		}
+	}()
+
+	var failure error
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return failure
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if err := framing.write(out, e.Data); err != nil {
+					return err
+				}
+			case ports.Stream:
+				data, err := io.ReadAll(e.Body)
+				e.Body.Close()
+				if err == nil {
+					err = framing.write(out, data)
+				}
+				if err != nil {
+					return err
+				}
+			case ports.Closed:
+				if e.Local {
+					return failure
+				}
+				if err := closeError(e); err != nil {
+					return err
+				}
+				return failure
+			case ports.Error:
+				fmt.Fprintf(errOut, "connection error: %v\n", e.Err)
+				return e.Err
+			}
+		case err := <-inputDone:
+			inputDone = nil
+			if err != nil {
+				fmt.Fprintf(errOut, "input error: %v\n", err)
+				failure = err
+			}
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		}
+	}
+}
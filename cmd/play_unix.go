@@ -0,0 +1,186 @@
+//go:build unix
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/asciicast"
+)
+
+// Bounds of the playback speed.
+const (
+	minPlaySpeed = 1.0 / 16
+	maxPlaySpeed = 16.0
+)
+
+var playCmd = &cobra.Command{
+	Use:   "play <file.cast>",
+	Short: "Replay a terminal recording",
+	Long: `Replay an asciicast v2 recording, such as one made with "share --record" or
+"attach --record", in your terminal.
+
+While playing, these keys control the playback:
+  space    pause or resume
+  .        show the next frame while paused
+  + / -    double or halve the speed
+  q        stop
+
+--speed sets the initial speed and --idle-time-limit shortens long pauses of
+the recording. The terminal is not resized; a recording made in a larger
+terminal may not render correctly in a smaller one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPlay,
+}
+
+func init() {
+	rootCmd.AddCommand(playCmd)
+	playCmd.Flags().Float64("speed", 1, "playback speed")
+	playCmd.Flags().Duration("idle-time-limit", 0, "longest pause between frames (0 keeps the recorded pauses)")
+}
+
+// player replays the output events of a recording.
+type player struct {
+	out       io.Writer
+	speed     float64
+	idleLimit time.Duration
+	// keys delivers the control keys pressed; nil when there are none.
+	keys <-chan byte
+}
+
+// play replays events until they run out, ctx is cancelled or q is pressed.
+// pos is how far into the recording playback is; it advances with the clock
+// at the current speed unless paused.
+func (p *player) play(ctx context.Context, events []asciicast.Event) {
+	var pos time.Duration
+	paused := false
+	last := time.Now()
+	advance := func() {
+		now := time.Now()
+		if !paused {
+			pos += time.Duration(float64(now.Sub(last)) * p.speed)
+		}
+		last = now
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for i := 0; i < len(events); {
+		ev := events[i]
+		if p.idleLimit > 0 && ev.Time-pos > p.idleLimit {
+			pos = ev.Time - p.idleLimit
+		}
+		var due <-chan time.Time
+		if !paused {
+			timer.Reset(time.Duration(float64(ev.Time-pos) / p.speed))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-due:
+			advance()
+			pos = max(pos, ev.Time)
+			p.emit(ev)
+			i++
+		case key, ok := <-p.keys:
+			advance()
+			if !ok {
+				p.keys = nil
+				continue
+			}
+			switch key {
+			case ' ':
+				paused = !paused
+			case '.':
+				if paused {
+					pos = ev.Time
+					p.emit(ev)
+					i++
+				}
+			case '+', '=':
+				p.speed = min(p.speed*2, maxPlaySpeed)
+			case '-', '_':
+				p.speed = max(p.speed/2, minPlaySpeed)
+			case 'q', 0x03:
+				return
+			}
+		}
+	}
+}
+
+// emit shows an event; only output is shown, as the local terminal keeps its
+// size.
+func (p *player) emit(ev asciicast.Event) {
+	if ev.Code == asciicast.Output {
+		io.WriteString(p.out, ev.Data)
+	}
+}
+
+// readKeys delivers the bytes read from r until it fails.
+func readKeys(r io.Reader) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 16)
+		for {
+			n, err := r.Read(buf)
+			for _, b := range buf[:n] {
+				keys <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return keys
+}
+
+func runPlay(cmd *cobra.Command, args []string) error {
+	speed, _ := cmd.Flags().GetFloat64("speed")
+	if speed < minPlaySpeed || speed > maxPlaySpeed {
+		return fmt.Errorf("--speed must be between %g and %g", minPlaySpeed, maxPlaySpeed)
+	}
+	idleLimit, _ := cmd.Flags().GetDuration("idle-time-limit")
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	h, events, err := asciicast.Read(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	out := cmd.OutOrStdout()
+	p := &player{out: out, speed: speed, idleLimit: idleLimit}
+	local := openLocalTerminal(cmd.InOrStdin())
+	if local != nil {
+		if cols, rows := local.size(); int(cols) < h.Width || int(rows) < h.Height {
+			fmt.Fprintf(cmd.ErrOrStderr(), "play: the recording is %dx%d but your terminal is %dx%d; it may not render correctly\n",
+				h.Width, h.Height, cols, rows)
+		}
+		// Raw mode delivers keys as they are pressed, Ctrl+C included.
+		if err := local.makeRaw(); err == nil {
+			defer local.restore()
+			p.keys = readKeys(cmd.InOrStdin())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	p.play(ctx, events)
+	local.restore()
+	fmt.Fprintln(out)
+	return nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/codec"
+	"github.com/ravenpair/cli/internal/filter"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// messagePrinter prints the messages received by connect. It decodes binary
+// messages with the session's codec, validates messages when --strict is set,
+// passes them through the pipeline and renders known events. A message that
+// cannot be decoded, fails validation or trips the filter is reported and
+// skipped rather than ending the session. With --exec, messages are handed
+// to the command instead of being printed.
+type messagePrinter struct {
+	out, errOut io.Writer
+	pipeline    *filter.Pipeline
+	strict      bool
+	friendly    bool
+	codec       codec.Codec
+	binaryOut   *binaryWriter
+	exec        interface{ handle(execMessage) }
+	seq         uint64
+}
+
+func newMessagePrinter(cmd *cobra.Command, out, errOut io.Writer, pipeline *filter.Pipeline) (*messagePrinter, error) {
+	raw, _ := cmd.Flags().GetBool("raw")
+	strict, _ := cmd.Flags().GetBool("strict")
+	format, _ := cmd.Flags().GetString("binary-format")
+	dir, _ := cmd.Flags().GetString("save-dir")
+	binaryOut, err := newBinaryWriter(out, format, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &messagePrinter{
+		out:       out,
+		errOut:    errOut,
+		pipeline:  pipeline,
+		strict:    strict,
+		friendly:  !raw && pipeline.Transform == nil,
+		codec:     codec.JSON,
+		binaryOut: binaryOut,
+	}, nil
+}
+
+func (p *messagePrinter) message(m ports.Message) {
+	p.seq++
+	data := m.Data
+	decoded := false
+	if m.Type == ports.BinaryMessage {
+		if !p.codec.Binary() {
+			p.binary(data)
+			return
+		}
+		doc, err := p.codec.Decode(data)
+		if err != nil {
+			fmt.Fprintf(p.errOut, "decode error: %v\n", err)
+			p.binary(data)
+			return
+		}
+		data, decoded = doc, true
+	}
+	if p.strict {
+		if err := protocol.Validate(data); err != nil {
+			fmt.Fprintf(p.errOut, "invalid message: %v\n", err)
+			return
+		}
+	}
+	msgs, err := p.pipeline.Apply(context.Background(), data)
+	if err != nil {
+		fmt.Fprintf(p.errOut, "filter error: %v\n", err)
+		return
+	}
+	for _, msg := range msgs {
+		if p.exec != nil {
+			p.exec.handle(execMessage{Seq: p.seq, Type: m.Type, Data: msg, Decoded: decoded})
+			continue
+		}
+		if p.friendly {
+			if e, err := protocol.Decode(msg); err == nil {
+				if line, ok := protocol.Format(e); ok {
+					fmt.Fprintln(p.out, line)
+					continue
+				}
+			}
+		}
+		fmt.Fprintf(p.out, "< %s\n", msg)
+	}
+}
+
+// binary shows a binary message that could not be decoded.
+func (p *messagePrinter) binary(data []byte) {
+	if !p.pipeline.KeepNonJSON() {
+		return
+	}
+	if p.exec != nil {
+		p.exec.handle(execMessage{Seq: p.seq, Type: ports.BinaryMessage, Data: data})
+		return
+	}
+	if _, err := p.binaryOut.write(bytes.NewReader(data), int64(len(data))); err != nil {
+		fmt.Fprintf(p.errOut, "binary output error: %v\n", err)
+	}
+}
+
+// stream prints a message too large to buffer as it arrives. Such messages
+// are neither decoded nor run through the pipeline and count as non-JSON. A
+// read error cuts the message short; the session reports it as its final
+// event. An --exec command gets the whole message once it has been read.
+func (p *messagePrinter) stream(s ports.Stream) {
+	defer s.Body.Close()
+	p.seq++
+	if !p.pipeline.KeepNonJSON() {
+		_, _ = io.Copy(io.Discard, s.Body)
+		return
+	}
+	if p.exec != nil {
+		data, err := io.ReadAll(s.Body)
+		if err == nil {
+			p.exec.handle(execMessage{Seq: p.seq, Type: s.Type, Data: data})
+		}
+		return
+	}
+	switch s.Type {
+	case ports.TextMessage:
+		fmt.Fprint(p.out, "< ")
+		_, _ = io.Copy(p.out, s.Body)
+		fmt.Fprintln(p.out)
+	case ports.BinaryMessage:
+		if _, err := p.binaryOut.write(s.Body, -1); err != nil {
+			fmt.Fprintf(p.errOut, "binary output error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often a progress line is redrawn at most.
+const progressInterval = 200 * time.Millisecond
+
+// progress shows how far a file transfer got on a line it keeps rewriting.
+type progress struct {
+	out  io.Writer
+	name string
+	size int64
+	// from is where the transfer started or resumed, for the rate.
+	from  int64
+	start time.Time
+	drawn time.Time
+	shown bool
+}
+
+func newProgress(out io.Writer, name string, size, from int64) *progress {
+	return &progress{out: out, name: name, size: size, from: from, start: time.Now()}
+}
+
+// update shows that the transfer reached offset. Between the first and the
+// last, updates closer together than progressInterval are skipped.
+func (p *progress) update(offset int64) {
+	now := time.Now()
+	if p.shown && offset < p.size && now.Sub(p.drawn) < progressInterval {
+		return
+	}
+	p.drawn = now
+	percent := int64(100)
+	if p.size > 0 {
+		percent = offset * 100 / p.size
+	}
+	line := fmt.Sprintf("%s: %3d%%, %s of %s", p.name, percent, formatByteSize(offset), formatByteSize(p.size))
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 && offset > p.from {
+		line += fmt.Sprintf(", %s/s", formatByteSize(int64(float64(offset-p.from)/elapsed)))
+	}
+	fmt.Fprintf(p.out, "\r%-64s", line)
+	p.shown = true
+}
+
+// end finishes the progress line, if one was shown.
+func (p *progress) end() {
+	if p.shown {
+		fmt.Fprintln(p.out)
+		p.shown = false
+	}
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// question is a yes or no question waiting for the user's answer.
+type question struct {
+	text string
+	// about identifies what the question is about to whoever asked it.
+	about interface{}
+	yes   bool
+}
+
+// promptUser asks the user each question from asks, one at a time, reading
+// the answers from in. Anything but y or yes, including the end of in, is
+// no.
+func promptUser(in io.Reader, out io.Writer, asks <-chan *question) <-chan *question {
+	answers := make(chan *question)
+	lines := bufio.NewScanner(in)
+	go func() {
+		for q := range asks {
+			q.yes = confirm(lines, out, q.text)
+			answers <- q
+		}
+	}()
+	return answers
+}
+
+// confirm asks a yes or no question, reading the answer from lines.
+func confirm(lines *bufio.Scanner, out io.Writer, text string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", text)
+	if !lines.Scan() {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(lines.Text()))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+	"github.com/ravenpair/cli/internal/transfer"
+)
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive",
+	Short: "Receive the files a pair sends",
+	Long: `Wait for the files others in the pair session given with --from offer with
+"ravenpair send", asking before accepting each one.
+
+An accepted file is written to a hidden partial file in --dir and only
+appears under its name once its SHA-256 digest is verified, replacing any
+file of that name. When a transfer is interrupted, the partial file is kept
+so that sending the same file again resumes from the last chunk received.`,
+	Args: cobra.NoArgs,
+	RunE: runReceive,
+}
+
+func init() {
+	rootCmd.AddCommand(receiveCmd)
+	receiveCmd.Flags().String("from", "", "pair session to receive files from")
+	receiveCmd.Flags().String("dir", ".", "directory to save the files in")
+	receiveCmd.Flags().Bool("once", false, "stop after receiving one file")
+	addSessionFlags(receiveCmd)
+}
+
+// fileReceiver receives the files offered in a pair session.
+type fileReceiver struct {
+	ctx    context.Context
+	sess   ports.Session
+	pairID string
+	node   uint32
+	dir    string
+	out    io.Writer
+	once   bool
+
+	// files are the transfers offered, waiting for an answer or accepted, by
+	// transfer.
+	files map[uint32]*incomingFile
+}
+
+type incomingFile struct {
+	offer    protocol.TransferOfferPayload
+	user     string
+	in       *transfer.Incoming
+	accepted bool
+	progress *progress
+}
+
+func runReceive(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "from", "receive.from")
+	if pairID == "" {
+		return errors.New("a pair is required: use --from")
+	}
+	dir, _ := cmd.Flags().GetString("dir")
+	if fi, err := os.Stat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	once, _ := cmd.Flags().GetBool("once")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+
+	r := &fileReceiver{
+		ctx:    ctx,
+		sess:   sess,
+		pairID: pairID,
+		node:   randomID(),
+		dir:    dir,
+		out:    &lockedWriter{w: cmd.OutOrStdout()},
+		once:   once,
+		files:  make(map[uint32]*incomingFile),
+	}
+	asks := make(chan *question, 16)
+	answers := promptUser(cmd.InOrStdin(), r.out, asks)
+	fmt.Fprintf(r.out, "Waiting for files in pair %s. Press Ctrl+C to stop.\n", pairID)
+
+	err = r.loop(cmd, asks, answers)
+	r.abortAll()
+	return err
+}
+
+func (r *fileReceiver) loop(cmd *cobra.Command, asks chan<- *question, answers <-chan *question) error {
+	interrupted := r.ctx.Done()
+	for {
+		select {
+		case ev, ok := <-r.sess.Events():
+			if !ok {
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				saved := false
+				if e.Type == ports.BinaryMessage {
+					if f, ok := protocol.DecodeTransferFrame(e.Data); ok {
+						saved = r.chunk(f)
+					}
+				} else if event, err := protocol.Decode(e.Data); err == nil {
+					r.handle(event, asks)
+				}
+				if err := r.saved(saved); err != nil {
+					return err
+				}
+			case ports.Closed, ports.Error:
+				r.abortAll()
+				return sessionEnded(cmd, r.out, ev, nil)
+			}
+		case q := <-answers:
+			if err := r.saved(r.answer(q.about.(*incomingFile), q.yes)); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			for id, f := range r.files {
+				if f.accepted {
+					r.send(protocol.TypeTransferCancel, protocol.TransferCancelPayload{Transfer: id, Node: r.node, Reason: "the receiver stopped"})
+				}
+			}
+			r.abortAll()
+			if err := interruptSession(r.out, r.sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// saved closes the session once a file was saved, with --once.
+func (r *fileReceiver) saved(saved bool) error {
+	if !saved || !r.once {
+		return nil
+	}
+	return r.sess.Close(ports.CloseNormalClosure, "")
+}
+
+// handle processes a transfer event from a sender.
+func (r *fileReceiver) handle(e protocol.Envelope, asks chan<- *question) {
+	switch e.Type {
+	case protocol.TypeTransferOffer:
+		var p protocol.TransferOfferPayload
+		if e.DecodePayload(&p) != nil || r.files[p.Transfer] != nil {
+			return
+		}
+		user := senderName(e.Sender)
+		in, err := transfer.NewIncoming(r.dir, p.Name, p.Size, p.SHA256, p.ChunkSize)
+		if err != nil {
+			fmt.Fprintf(r.out, "Declined a file from %s: %v\n", user, err)
+			r.send(protocol.TypeTransferReject, protocol.TransferRejectPayload{Transfer: p.Transfer, Node: r.node, Reason: err.Error()})
+			return
+		}
+		f := &incomingFile{offer: p, user: user, in: in}
+		text := fmt.Sprintf("%s offers %s (%s).", user, p.Name, formatByteSize(p.Size))
+		if in.Offset() > 0 {
+			text += fmt.Sprintf(" %s of it was received before; accepting resumes the transfer.", formatByteSize(in.Offset()))
+		}
+		if _, err := os.Lstat(in.Path); err == nil {
+			text += fmt.Sprintf(" It replaces %s.", in.Path)
+		}
+		select {
+		case asks <- &question{text: text + " Accept?", about: f}:
+			r.files[p.Transfer] = f
+		default:
+			r.send(protocol.TypeTransferReject, protocol.TransferRejectPayload{Transfer: p.Transfer, Node: r.node, Reason: "too many pending offers"})
+		}
+
+	case protocol.TypeTransferCancel:
+		var p protocol.TransferCancelPayload
+		if e.DecodePayload(&p) != nil || (p.Node != 0 && p.Node != r.node) {
+			return
+		}
+		f, ok := r.files[p.Transfer]
+		if !ok {
+			return
+		}
+		delete(r.files, p.Transfer)
+		if !f.accepted {
+			fmt.Fprintf(r.out, "%s withdrew %s.\n", f.user, f.offer.Name)
+			return
+		}
+		f.progress.end()
+		f.in.Abort()
+		fmt.Fprintf(r.out, "%s cancelled %s: %s\n", f.user, f.offer.Name, p.Reason)
+		if f.in.Offset() > 0 {
+			fmt.Fprintf(r.out, "%s of it is kept; sending the file again resumes the transfer.\n", formatByteSize(f.in.Offset()))
+		}
+	}
+}
+
+// answer accepts or declines an offer, as the user said. It reports whether
+// that saved the file, as it does when it was already complete.
+func (r *fileReceiver) answer(f *incomingFile, ok bool) bool {
+	id := f.offer.Transfer
+	if r.files[id] != f {
+		return false // withdrawn meanwhile
+	}
+	if !ok {
+		delete(r.files, id)
+		fmt.Fprintln(r.out, "Declined.")
+		r.send(protocol.TypeTransferReject, protocol.TransferRejectPayload{Transfer: id, Node: r.node})
+		return false
+	}
+	if err := f.in.Start(); err != nil {
+		delete(r.files, id)
+		fmt.Fprintf(r.out, "Cannot save %s: %v\n", f.offer.Name, err)
+		r.send(protocol.TypeTransferReject, protocol.TransferRejectPayload{Transfer: id, Node: r.node, Reason: "the receiver cannot save the file"})
+		return false
+	}
+	f.accepted = true
+	f.progress = newProgress(r.out, f.offer.Name, f.offer.Size, f.in.Offset())
+	r.send(protocol.TypeTransferAccept, protocol.TransferAcceptPayload{Transfer: id, Node: r.node, Offset: f.in.Offset()})
+	if f.in.Complete() {
+		return r.finish(f)
+	}
+	return false
+}
+
+// chunk writes a chunk of an accepted file, reporting whether it completed
+// the file.
+func (r *fileReceiver) chunk(c protocol.TransferFrame) bool {
+	f, ok := r.files[c.Transfer]
+	if !ok || !f.accepted {
+		return false
+	}
+	switch {
+	case c.Offset < f.in.Offset():
+		return false // already written
+	case c.Offset > f.in.Offset():
+		r.fail(f, "a chunk is missing")
+		return false
+	}
+	if err := f.in.Write(c.Data); err != nil {
+		r.fail(f, err.Error())
+		return false
+	}
+	r.send(protocol.TypeTransferAck, protocol.TransferAckPayload{Transfer: c.Transfer, Node: r.node, Offset: f.in.Offset()})
+	f.progress.update(f.in.Offset())
+	if !f.in.Complete() {
+		return false
+	}
+	return r.finish(f)
+}
+
+// finish verifies and saves a complete file, reporting whether it was saved.
+func (r *fileReceiver) finish(f *incomingFile) bool {
+	id := f.offer.Transfer
+	delete(r.files, id)
+	f.progress.update(f.in.Offset())
+	f.progress.end()
+	if err := f.in.Finish(); err != nil {
+		fmt.Fprintf(r.out, "Cannot save %s: %v\n", f.offer.Name, err)
+		r.send(protocol.TypeTransferDone, protocol.TransferDonePayload{Transfer: id, Node: r.node, Error: err.Error()})
+		return false
+	}
+	fmt.Fprintf(r.out, "Saved %s (%s, SHA-256 verified).\n", f.in.Path, formatByteSize(f.offer.Size))
+	r.send(protocol.TypeTransferDone, protocol.TransferDonePayload{Transfer: id, Node: r.node, OK: true})
+	return true
+}
+
+// fail abandons an accepted file, keeping what was written.
+func (r *fileReceiver) fail(f *incomingFile, reason string) {
+	delete(r.files, f.offer.Transfer)
+	f.progress.end()
+	f.in.Abort()
+	fmt.Fprintf(r.out, "Stopped receiving %s: %s\n", f.offer.Name, reason)
+	r.send(protocol.TypeTransferCancel, protocol.TransferCancelPayload{Transfer: f.offer.Transfer, Node: r.node, Reason: reason})
+}
+
+// abortAll abandons the files being received, keeping what was written so
+// that the transfers can resume.
+func (r *fileReceiver) abortAll() {
+	for id, f := range r.files {
+		if f.accepted {
+			f.progress.end()
+			f.in.Abort()
+		}
+		delete(r.files, id)
+	}
+}
+
+func (r *fileReceiver) send(eventType string, payload interface{}) {
+	_ = sendEvent(context.Background(), r.sess, eventType, r.pairID, payload)
+}
@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/app"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+	"github.com/ravenpair/cli/internal/webhook"
+)
+
+var relayCmd = &cobra.Command{
+	Use:   "relay [pair]",
+	Short: "Forward session messages to an HTTP webhook",
+	Long: `Keep a WebSocket session open, to the given pair session or to the
+server's endpoint, and POST every message received to the URL given with --to.
+
+By default the request body is the JSON object that connect --exec-persistent
+reads: {"seq":1,"type":"text","size":42,"payload":...}. --template replaces it
+with a Go text/template, given inline or as @file, that sees these fields:
+  .Seq      message number in this session, from 1
+  .Type     text or binary
+  .Size     message size in bytes
+  .Text     the message as text
+  .Base64   the message in base64
+  .JSON     the decoded message, if it is JSON
+  .Event    the event envelope (.Event.Type, .Event.Sender, ...), if any
+  .Message  the default body
+and a json function that encodes a value as JSON:
+
+  ravenpair relay p1 --to https://chat.corp/hook \
+      --template '{"text": {{json .Text}}}'
+
+With --secret (or RAVENPAIR_RELAY_SECRET), each request carries the hex
+HMAC-SHA256 of its body in an X-RavenPair-Signature-256: sha256=... header.
+Failed deliveries are retried with exponential backoff when the error may be
+temporary: network errors, HTTP 408, 429 and 5xx. Messages that still could
+not be delivered are appended to the --dead-letter file, one JSON object per
+line, with the error and the original message. So are messages that arrive
+while 1024 others are still waiting for a slow endpoint.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRelay,
+}
+
+func init() {
+	rootCmd.AddCommand(relayCmd)
+	relayCmd.Flags().String("to", "", "webhook URL to POST messages to")
+	addSessionFlags(relayCmd)
+	relayCmd.Flags().String("template", "", "request body template, inline or @file")
+	relayCmd.Flags().String("content-type", "application/json", "Content-Type of the requests")
+	relayCmd.Flags().String("secret", "", "shared secret for the HMAC signature header")
+	relayCmd.Flags().Int("retries", 5, "how many times to retry a failed delivery")
+	relayCmd.Flags().Duration("retry-backoff", time.Second, "wait before the first retry; doubled for each further one")
+	relayCmd.Flags().Duration("timeout", 10*time.Second, "timeout of each delivery attempt")
+	relayCmd.Flags().String("dead-letter", "ravenpair-relay.dead.ndjson", "file that collects messages that could not be delivered")
+}
+
+// relayEvent is what a --template sees of a received message.
+type relayEvent struct {
+	Seq     uint64
+	Type    string
+	Size    int
+	Text    string
+	Base64  string
+	JSON    interface{}
+	Event   *protocol.Envelope
+	Message string
+}
+
+func newRelayEvent(m execMessage) relayEvent {
+	line, _ := json.Marshal(newNDJSONMessage(m))
+	ev := relayEvent{
+		Seq:     m.Seq,
+		Type:    m.typeName(),
+		Size:    len(m.Data),
+		Text:    string(m.Data),
+		Base64:  base64.StdEncoding.EncodeToString(m.Data),
+		Message: string(line),
+	}
+	if m.textual() {
+		_ = json.Unmarshal(m.Data, &ev.JSON)
+		if e, err := protocol.Decode(m.Data); err == nil {
+			ev.Event = &e
+		}
+	}
+	return ev
+}
+
+// deliveryID identifies a delivery by the pair and sequence number of its
+// event, or by the message number when it is not an event.
+func (ev relayEvent) deliveryID() string {
+	if ev.Event != nil && ev.Event.PairID != "" {
+		return fmt.Sprintf("%s-%d", ev.Event.PairID, ev.Event.Seq)
+	}
+	return strconv.FormatUint(ev.Seq, 10)
+}
+
+func (ev relayEvent) eventType() string {
+	if ev.Event != nil {
+		return ev.Event.Type
+	}
+	return ""
+}
+
+// parseBodyTemplate parses a --template value; a leading @ names a file.
+func parseBodyTemplate(value string) (*template.Template, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+		value = string(data)
+	}
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Option("missingkey=error").Parse(value)
+}
+
+// relayQueueSize is how many messages may wait for delivery.
+const relayQueueSize = 1024
+
+// errRelayQueueFull dead-letters a message that arrived while the delivery
+// queue was full.
+var errRelayQueueFull = fmt.Errorf("%d messages were already waiting for delivery", relayQueueSize)
+
+// relay delivers messages one at a time, in order, and dead-letters those it
+// cannot deliver.
+type relay struct {
+	sender     *webhook.Sender
+	tmpl       *template.Template
+	deadLetter *webhook.DeadLetterFile
+	errOut     io.Writer
+
+	mu                   sync.Mutex
+	delivered, deadCount int
+}
+
+// body renders the request body of m.
+func (r *relay) body(m execMessage) (relayEvent, []byte, error) {
+	ev := newRelayEvent(m)
+	if r.tmpl == nil {
+		return ev, []byte(ev.Message), nil
+	}
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, ev); err != nil {
+		return ev, buf.Bytes(), fmt.Errorf("rendering template: %w", err)
+	}
+	return ev, buf.Bytes(), nil
+}
+
+func (r *relay) deliver(ctx context.Context, m execMessage) {
+	ev, body, err := r.body(m)
+	attempts := 0
+	if err == nil {
+		attempts, err = r.sender.Deliver(ctx, ev.deliveryID(), ev.eventType(), body)
+	}
+	if err == nil {
+		r.mu.Lock()
+		r.delivered++
+		r.mu.Unlock()
+		return
+	}
+	r.bury(m, ev, body, attempts, err)
+}
+
+// enqueue hands m to the deliveries without waiting for them, so that a slow
+// endpoint cannot hold up the connection; when the queue is full, m goes to
+// the dead-letter file instead.
+func (r *relay) enqueue(queue chan<- execMessage, m execMessage) {
+	select {
+	case queue <- m:
+	default:
+		ev, body, err := r.body(m)
+		if err == nil {
+			err = errRelayQueueFull
+		}
+		r.bury(m, ev, body, 0, err)
+	}
+}
+
+// bury reports why m was not delivered and appends it to the dead-letter
+// file.
+func (r *relay) bury(m execMessage, ev relayEvent, body []byte, attempts int, err error) {
+	r.mu.Lock()
+	r.deadCount++
+	r.mu.Unlock()
+	fmt.Fprintf(r.errOut, "relay: message %d not delivered after %d attempt(s): %v\n", m.Seq, attempts, err)
+	dl := webhook.DeadLetter{
+		Time:     time.Now().UTC(),
+		ID:       ev.deliveryID(),
+		Event:    ev.eventType(),
+		Attempts: attempts,
+		Error:    err.Error(),
+		Body:     string(body),
+		Message:  json.RawMessage(ev.Message),
+	}
+	if err := r.deadLetter.Add(dl); err != nil {
+		fmt.Fprintf(r.errOut, "relay: writing dead letter %s: %v\n", r.deadLetter.Path(), err)
+	}
+}
+
+func runRelay(cmd *cobra.Command, args []string) error {
+	target := stringSetting(cmd, "to", "relay.to")
+	if target == "" {
+		return fmt.Errorf("a webhook URL is required: use --to")
+	}
+	templateValue, _ := cmd.Flags().GetString("template")
+	tmpl, err := parseBodyTemplate(templateValue)
+	if err != nil {
+		return err
+	}
+	contentType, _ := cmd.Flags().GetString("content-type")
+	retries, _ := cmd.Flags().GetInt("retries")
+	backoff, _ := cmd.Flags().GetDuration("retry-backoff")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	deadLetterPath, _ := cmd.Flags().GetString("dead-letter")
+
+	senderOpts := []webhook.Option{webhook.WithContentType(contentType), webhook.WithRetries(retries, backoff)}
+	if secret := stringSetting(cmd, "secret", "relay.secret"); secret != "" {
+		senderOpts = append(senderOpts, webhook.WithSecret([]byte(secret)))
+	}
+	if timeout > 0 {
+		senderOpts = append(senderOpts, webhook.WithHTTPClient(&http.Client{Timeout: timeout}))
+	}
+	r := &relay{
+		sender:     webhook.New(target, senderOpts...),
+		tmpl:       tmpl,
+		deadLetter: webhook.NewDeadLetterFile(deadLetterPath),
+		errOut:     cmd.ErrOrStderr(),
+	}
+
+	path := stringSetting(cmd, "path", "connect.path")
+	if len(args) == 1 {
+		path = app.PairPath(path, args[0])
+	}
+	serverURL := viper.GetString("server")
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Relaying %s%s to %s\n", serverURL, path, target)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+
+	// Deliveries run on their own so that a slow endpoint does not hold up
+	// the connection; they keep the order of the messages. Once interrupted,
+	// retries stop and whatever is left goes to the dead-letter file.
+	queue := make(chan execMessage, relayQueueSize)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for m := range queue {
+			r.deliver(ctx, m)
+		}
+	}()
+	defer func() {
+		close(queue)
+		wg.Wait()
+		fmt.Fprintf(out, "Relayed %d message(s)", r.delivered)
+		if r.deadCount > 0 {
+			fmt.Fprintf(out, "; %d could not be delivered, see %s", r.deadCount, r.deadLetter.Path())
+		}
+		fmt.Fprintln(out)
+	}()
+
+	var seq uint64
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Opened:
+				fmt.Fprintln(out, "Connected. Press Ctrl+C to stop relaying.")
+			case ports.Message:
+				seq++
+				r.enqueue(queue, execMessage{Seq: seq, Type: e.Type, Data: e.Data})
+			case ports.Stream:
+				seq++
+				data, err := io.ReadAll(e.Body)
+				e.Body.Close()
+				if err == nil {
+					r.enqueue(queue, execMessage{Seq: seq, Type: e.Type, Data: data})
+				}
+			case ports.Closed:
+				if e.Local {
+					fmt.Fprintf(out, "Connection closed: %s\n", describeClose(e))
+					return nil
+				}
+				return serverClosed(cmd.ErrOrStderr(), e)
+			case ports.Error:
+				fmt.Fprintf(cmd.ErrOrStderr(), "connection error: %v\n", e.Err)
+				return e.Err
+			}
+		case <-interrupted:
+			interrupted = nil
+			fmt.Fprintln(out, "\nInterrupted. Closing connection...")
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		}
+	}
+}
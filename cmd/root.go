@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -10,10 +14,22 @@ import (
 	"github.com/ravenpair/cli/internal/adapters/http"
 	"github.com/ravenpair/cli/internal/adapters/ws"
 	"github.com/ravenpair/cli/internal/app"
+	"github.com/ravenpair/cli/internal/config"
+	"github.com/ravenpair/cli/internal/knownservers"
+	"github.com/ravenpair/cli/internal/transport"
 )
 
 var cfgFile string
 
+// Config layers recorded by initConfig so that "config view --show-origin"
+// can explain where each effective setting came from.
+var (
+	homeConfigFile    string
+	homeConfigKeys    map[string]bool
+	projectConfigFile string
+	projectConfigKeys map[string]bool
+)
+
 // svc is the application service used by all sub-commands. It is wired with
 // concrete adapters in PersistentPreRunE and can be replaced in tests.
 var svc *app.Service
@@ -23,31 +39,182 @@ var rootCmd = &cobra.Command{
 	Short: "CLI tool to interact with the RavenPair server",
 	Long: `ravenpair is a command-line interface for connecting to and managing
 a RavenPair server via its REST API and WebSocket interface.`,
+	// Execute reports errors, once, with the exit code they carry.
+	SilenceErrors: true,
+	SilenceUsage:  true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		serverURL := viper.GetString("server")
-		token := viper.GetString("token")
-		svc = app.New(http.New(serverURL, token), ws.New())
-		return nil
+		var err error
+		svc, err = newService(cmd)
+		return err
 	},
 }
 
+// newService wires the HTTP and WebSocket adapters from the global settings so
+// that both reach the server through the same TLS, proxy and dial setup.
+func newService(cmd *cobra.Command) (*app.Service, error) {
+	serverURL := viper.GetString("server")
+	token := viper.GetString("token")
+
+	tlsConfig, err := newTLSConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := transport.ProxyFunc(viper.GetString("proxy"))
+	if err != nil {
+		return nil, err
+	}
+	header, err := transport.ParseHeaders(viper.GetStringSlice("header"))
+	if err != nil {
+		return nil, err
+	}
+	httpOpts := []http.Option{http.WithTLSConfig(tlsConfig), http.WithProxy(proxy), http.WithHeader(header)}
+	wsOpts := []ws.Option{ws.WithTLSConfig(tlsConfig), ws.WithProxy(proxy), ws.WithHeader(header),
+		ws.WithOrigin(viper.GetString("origin"))}
+
+	socket := viper.GetString("unix-socket")
+	if path, ok := transport.SocketPath(serverURL); ok {
+		socket = path
+	}
+	var dial transport.DialFunc
+	if socket != "" {
+		dial = transport.UnixDialer(socket)
+		httpOpts = append(httpOpts, http.WithProxy(nil))
+		wsOpts = append(wsOpts, ws.WithProxy(nil))
+	} else if resolve := viper.GetStringSlice("resolve"); len(resolve) > 0 {
+		overrides, err := transport.ParseResolve(resolve)
+		if err != nil {
+			return nil, err
+		}
+		dial = transport.ResolveDialer(overrides)
+	}
+	if dial != nil {
+		httpOpts = append(httpOpts, http.WithDialContext(dial))
+		wsOpts = append(wsOpts, ws.WithDialContext(dial))
+	}
+
+	return app.New(http.New(serverURL, token, httpOpts...), ws.New(wsOpts...)), nil
+}
+
 // Execute runs the root command.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var mismatch *knownservers.MismatchError
+		if errors.As(err, &mismatch) {
+			fmt.Fprintln(os.Stderr, mismatch.Warning())
+		}
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return fmt.Errorf("%w\nRun '%s --help' for usage.", err, c.CommandPath())
+	})
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default $HOME/.ravenpair.yaml)")
-	rootCmd.PersistentFlags().String("server", "http://localhost:8080", "RavenPair server URL")
+	rootCmd.PersistentFlags().String("server", "http://localhost:8080", "RavenPair server URL (http://, https:// or unix:///path/to/socket)")
+	rootCmd.PersistentFlags().String("unix-socket", "", "connect to the server through this Unix domain socket")
 	rootCmd.PersistentFlags().String("token", "", "authentication token")
 
-	_ = viper.BindPFlag("server", rootCmd.PersistentFlags().Lookup("server"))
-	_ = viper.BindPFlag("token", rootCmd.PersistentFlags().Lookup("token"))
+	rootCmd.PersistentFlags().String("cacert", "", "PEM file with CA certificates to trust in addition to the system roots")
+	rootCmd.PersistentFlags().String("cert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().String("key", "", "PEM private key for the client certificate")
+	rootCmd.PersistentFlags().String("tls-server-name", "", "server name to use for SNI and certificate verification")
+	rootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "DANGEROUS: do not verify the server certificate, exposing the connection to interception")
+	rootCmd.PersistentFlags().StringSlice("pin-sha256", nil, "base64 SHA-256 hash of an acceptable public key for this server, in addition to the pins setting (repeatable)")
+	rootCmd.PersistentFlags().String("proxy", "", "proxy URL (http:// or socks5://, optionally with user:password@); defaults to HTTP(S)_PROXY")
+	rootCmd.PersistentFlags().StringArrayP("header", "H", nil, "extra 'Name: value' header sent to the server on every request (repeatable)")
+	rootCmd.PersistentFlags().String("origin", "", "Origin header to send in the WebSocket handshake")
+	rootCmd.PersistentFlags().StringArray("resolve", nil, "connect to addr instead of resolving host:port, given as host:port:addr (repeatable)")
+	rootCmd.PersistentFlags().Bool("tofu", false, "trust the server certificate seen on first use instead of the CAs, and reject any later change (see 'servers')")
+	rootCmd.PersistentFlags().String("known-servers", "", "file of recorded server certificates (default ravenpair/known_servers in the user config directory)")
+
+	for _, name := range []string{
+		"server", "token", "unix-socket", "proxy", "header", "origin", "resolve",
+		"cacert", "cert", "key", "tls-server-name", "insecure-skip-verify", "tofu", "known-servers",
+	} {
+		_ = viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name))
+	}
+}
+
+// newTLSConfig builds the TLS configuration shared by the HTTP and WebSocket
+// adapters. It returns nil when the defaults apply.
+func newTLSConfig(cmd *cobra.Command) (*tls.Config, error) {
+	serverURL := viper.GetString("server")
+	pins, err := serverPins(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	flagPins, _ := cmd.Flags().GetStringSlice("pin-sha256")
+	opts := transport.TLSOptions{
+		CACertFile:         viper.GetString("cacert"),
+		CertFile:           viper.GetString("cert"),
+		KeyFile:            viper.GetString("key"),
+		ServerName:         viper.GetString("tls-server-name"),
+		InsecureSkipVerify: viper.GetBool("insecure-skip-verify"),
+		PinSHA256:          append(flagPins, pins...),
+	}
+	if viper.GetBool("tofu") && !opts.InsecureSkipVerify && usesTLS(serverURL) {
+		store, err := knownServersStore()
+		if err != nil {
+			return nil, err
+		}
+		hostPort, err := serverHostPort(serverURL)
+		if err != nil {
+			return nil, err
+		}
+		opts.TrustOnFirstUse = store.Verifier(hostPort)
+	}
+	if opts.InsecureSkipVerify {
+		fmt.Fprintln(cmd.ErrOrStderr(), "WARNING: TLS certificate verification is disabled (--insecure-skip-verify). "+
+			"Anyone on the network path can read and modify this connection, including your token.")
+	}
+	return transport.NewTLSConfig(opts)
+}
+
+// serverPin is an entry of the pins setting, which lists the acceptable
+// public keys of one server:
+//
+//	pins:
+//	  - server: ravenpair.example.com:8443
+//	    sha256: ["sha256//..."]
+//
+// Server is a URL, a host:port or a bare host name matching every port.
+type serverPin struct {
+	Server string   `mapstructure:"server"`
+	SHA256 []string `mapstructure:"sha256"`
+}
+
+// serverPins returns the pins that the pins setting lists for serverURL.
+func serverPins(serverURL string) ([]string, error) {
+	var entries []serverPin
+	if err := viper.UnmarshalKey("pins", &entries); err != nil {
+		return nil, fmt.Errorf("reading the pins setting: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	hostPort, err := serverHostPort(serverURL)
+	if err != nil || hostPort == "" {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(hostPort)
+
+	var pins []string
+	for _, e := range entries {
+		server := e.Server
+		if strings.Contains(server, "://") {
+			if server, err = serverHostPort(server); err != nil {
+				return nil, fmt.Errorf("pins entry %q: %w", e.Server, err)
+			}
+		}
+		if server == hostPort || server == host {
+			pins = append(pins, e.SHA256...)
+		}
+	}
+	return pins, nil
 }
 
 func initConfig() {
@@ -65,9 +232,65 @@ func initConfig() {
 	}
 
 	viper.SetEnvPrefix("RAVENPAIR")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AutomaticEnv()
 
+	homeConfigKeys = map[string]bool{}
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+		homeConfigFile = viper.ConfigFileUsed()
+		for _, key := range viper.AllKeys() {
+			if viper.InConfig(key) {
+				homeConfigKeys[key] = true
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Using config file:", homeConfigFile)
+	}
+
+	if err := loadProjectConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// loadProjectConfig merges the nearest .ravenpair.yaml or ravenpair.toml found
+// above the working directory over the home config. Environment variables and
+// flags still take precedence over it.
+func loadProjectConfig() error {
+	projectConfigKeys = map[string]bool{}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	home, _ := os.UserHomeDir()
+	path, err := config.FindProjectFile(cwd, home)
+	if err != nil || path == "" {
+		return err
+	}
+
+	settings, err := config.LoadProjectFile(path)
+	if err != nil {
+		return err
+	}
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("merging project config %s: %w", path, err)
+	}
+
+	projectConfigFile = path
+	for _, key := range config.Keys(settings) {
+		projectConfigKeys[key] = true
+	}
+	fmt.Fprintln(os.Stderr, "Using project config file:", path)
+	return nil
+}
+
+// stringSetting returns the value of the named flag when it was set on the
+// command line, otherwise the value of key from the environment or config
+// files, falling back to the flag default.
+func stringSetting(cmd *cobra.Command, flag, key string) string {
+	value, _ := cmd.Flags().GetString(flag)
+	if !cmd.Flags().Changed(flag) && viper.IsSet(key) {
+		value = viper.GetString(key)
 	}
+	return value
 }
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+	"github.com/ravenpair/cli/internal/transfer"
+)
+
+// sendWindow is how many chunks may be sent ahead of the receiver's
+// acknowledgements.
+const sendWindow = 16
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file>",
+	Short: "Send a file to a pair",
+	Long: `Offer a file to the pair session given with --to. Someone in the pair accepts
+it with "ravenpair receive", and the file is sent in chunks over the session.
+
+The receiver verifies the file's SHA-256 digest before saving it. If the
+transfer is interrupted, sending the same file again resumes it from the
+last chunk the receiver acknowledged.
+
+--rate-limit caps the transfer rate, in bytes per second with an optional
+unit such as KiB or MB; it can also be set as transfer.rate_limit in the
+config.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSend,
+}
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	sendCmd.Flags().String("to", "", "pair session to send the file to")
+	sendCmd.Flags().String("rate-limit", "", "most bytes sent per second, such as 512KiB (none by default)")
+	sendCmd.Flags().String("chunk-size", "64KiB", "size of the chunks the file is sent in")
+	addSessionFlags(sendCmd)
+}
+
+// fileSender sends one file to whoever accepts it first.
+type fileSender struct {
+	ctx    context.Context
+	sess   ports.Session
+	pairID string
+	file   *os.File
+	offer  protocol.TransferOfferPayload
+	rate   int64
+	out    io.Writer
+
+	// receiver is the node of the receiver that accepted; zero until then.
+	receiver uint32
+	user     string
+	progress *progress
+	// acked is how far the receiver acknowledged, and acks signals each
+	// acknowledgement to pump.
+	acked atomic.Int64
+	acks  chan struct{}
+}
+
+func runSend(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "to", "send.to")
+	if pairID == "" {
+		return errors.New("a pair is required: use --to")
+	}
+	rate, err := parseByteSize(stringSetting(cmd, "rate-limit", "transfer.rate_limit"))
+	if err != nil {
+		return fmt.Errorf("--rate-limit: %w", err)
+	}
+	chunkSize, err := byteSizeFlag(cmd, "chunk-size")
+	if err != nil {
+		return err
+	}
+	if chunkSize <= 0 || chunkSize > 4<<20 {
+		return errors.New("--chunk-size must be between 1 byte and 4 MiB")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", args[0])
+	}
+	sum, err := transfer.HashFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+
+	s := &fileSender{
+		ctx:    ctx,
+		sess:   sess,
+		pairID: pairID,
+		file:   file,
+		offer: protocol.TransferOfferPayload{
+			Transfer:  randomID(),
+			Name:      filepath.Base(args[0]),
+			Size:      fi.Size(),
+			SHA256:    sum,
+			ChunkSize: int(chunkSize),
+		},
+		rate: rate,
+		out:  cmd.OutOrStdout(),
+		acks: make(chan struct{}, 1),
+	}
+	if err := sendEvent(ctx, sess, protocol.TypeTransferOffer, pairID, s.offer); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Offered %s (%s) to pair %s. Waiting for someone to accept it with \"ravenpair receive\"...\n",
+		s.offer.Name, formatByteSize(s.offer.Size), pairID)
+	return s.loop(cmd)
+}
+
+func (s *fileSender) loop(cmd *cobra.Command) error {
+	pumpCtx, cancelPump := context.WithCancel(s.ctx)
+	defer cancelPump()
+	pumpErr := make(chan error, 1)
+
+	var failure error
+	fail := func(err error) error {
+		if s.progress != nil {
+			s.progress.end()
+		}
+		failure = err
+		fmt.Fprintf(cmd.ErrOrStderr(), "send: %v\n", err)
+		return s.sess.Close(ports.CloseNormalClosure, "")
+	}
+	interrupted := s.ctx.Done()
+	for {
+		select {
+		case ev, ok := <-s.sess.Events():
+			if !ok {
+				return failure
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if e.Type != ports.TextMessage {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil {
+					continue
+				}
+				done, err := s.handle(event, func(from int64) {
+					go func() { pumpErr <- s.pump(pumpCtx, from) }()
+				})
+				if err != nil && failure == nil {
+					cancelPump()
+					if err := fail(err); err != nil {
+						return err
+					}
+				} else if done {
+					if err := s.sess.Close(ports.CloseNormalClosure, ""); err != nil {
+						return err
+					}
+				}
+			case ports.Closed, ports.Error:
+				if c, ok := e.(ports.Closed); !ok || !c.Local {
+					s.interrupted()
+				}
+				return sessionEnded(cmd, s.out, ev, failure)
+			}
+		case err := <-pumpErr:
+			if err != nil && failure == nil {
+				s.cancel(err.Error())
+				if err := fail(err); err != nil {
+					return err
+				}
+			}
+		case <-interrupted:
+			interrupted = nil
+			cancelPump()
+			s.cancel("the sender stopped")
+			s.interrupted()
+			if err := interruptSession(s.out, s.sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle processes a transfer event, calling start when a receiver accepts
+// the file. It reports whether the transfer is done, or the error that ended
+// it.
+func (s *fileSender) handle(e protocol.Envelope, start func(from int64)) (bool, error) {
+	switch e.Type {
+	case protocol.TypeTransferAccept:
+		var p protocol.TransferAcceptPayload
+		if e.DecodePayload(&p) != nil || p.Transfer != s.offer.Transfer {
+			return false, nil
+		}
+		if s.receiver != 0 {
+			if p.Node != s.receiver {
+				s.sendCancel(p.Node, "someone else accepted the file")
+			}
+			return false, nil
+		}
+		if p.Offset < 0 || p.Offset > s.offer.Size {
+			s.sendCancel(p.Node, "invalid offset")
+			return false, nil
+		}
+		s.receiver, s.user = p.Node, senderName(e.Sender)
+		s.acked.Store(p.Offset)
+		if p.Offset > 0 {
+			fmt.Fprintf(s.out, "%s accepted %s; resuming after %s.\n", s.user, s.offer.Name, formatByteSize(p.Offset))
+		} else {
+			fmt.Fprintf(s.out, "%s accepted %s.\n", s.user, s.offer.Name)
+		}
+		s.progress = newProgress(s.out, s.offer.Name, s.offer.Size, p.Offset)
+		s.progress.update(p.Offset)
+		start(p.Offset)
+
+	case protocol.TypeTransferReject:
+		var p protocol.TransferRejectPayload
+		if e.DecodePayload(&p) != nil || p.Transfer != s.offer.Transfer || s.receiver != 0 {
+			return false, nil
+		}
+		if p.Reason != "" {
+			return false, fmt.Errorf("%s declined %s: %s", senderName(e.Sender), s.offer.Name, p.Reason)
+		}
+		return false, fmt.Errorf("%s declined %s", senderName(e.Sender), s.offer.Name)
+
+	case protocol.TypeTransferAck:
+		var p protocol.TransferAckPayload
+		if e.DecodePayload(&p) != nil || p.Transfer != s.offer.Transfer || p.Node != s.receiver || s.receiver == 0 {
+			return false, nil
+		}
+		s.acked.Store(p.Offset)
+		select {
+		case s.acks <- struct{}{}:
+		default:
+		}
+		s.progress.update(p.Offset)
+
+	case protocol.TypeTransferDone:
+		var p protocol.TransferDonePayload
+		if e.DecodePayload(&p) != nil || p.Transfer != s.offer.Transfer || p.Node != s.receiver || s.receiver == 0 {
+			return false, nil
+		}
+		if !p.OK {
+			return false, fmt.Errorf("%s could not save %s: %s", s.user, s.offer.Name, p.Error)
+		}
+		s.progress.update(s.offer.Size)
+		s.progress.end()
+		fmt.Fprintf(s.out, "Sent %s; %s verified its SHA-256 digest.\n", s.offer.Name, s.user)
+		return true, nil
+
+	case protocol.TypeTransferCancel:
+		var p protocol.TransferCancelPayload
+		if e.DecodePayload(&p) != nil || p.Transfer != s.offer.Transfer || p.Node != s.receiver || s.receiver == 0 {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s cancelled the transfer: %s", s.user, p.Reason)
+	}
+	return false, nil
+}
+
+// pump sends the file from offset from, keeping at most sendWindow chunks
+// ahead of the receiver.
+func (s *fileSender) pump(ctx context.Context, from int64) error {
+	if _, err := s.file.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	chunk := int64(s.offer.ChunkSize)
+	limiter := transfer.NewLimiter(s.rate)
+	buf := make([]byte, chunk)
+	for offset := from; offset < s.offer.Size; {
+		for offset-s.acked.Load() >= sendWindow*chunk {
+			select {
+			case <-s.acks:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		n, err := io.ReadFull(s.file, buf[:min(chunk, s.offer.Size-offset)])
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", s.offer.Name, err)
+		}
+		if err := limiter.Wait(ctx, n); err != nil {
+			return nil
+		}
+		frame := protocol.EncodeTransferFrame(protocol.TransferFrame{Transfer: s.offer.Transfer, Offset: offset, Data: buf[:n]})
+		if err := s.sess.Send(ctx, ports.BinaryMessage, frame); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
+
+// cancel tells the receiver, if any, that the transfer is abandoned.
+func (s *fileSender) cancel(reason string) {
+	s.sendCancel(s.receiver, reason)
+}
+
+func (s *fileSender) sendCancel(node uint32, reason string) {
+	_ = sendEvent(context.Background(), s.sess, protocol.TypeTransferCancel, s.pairID, protocol.TransferCancelPayload{Transfer: s.offer.Transfer, Node: node, Reason: reason})
+}
+
+// interrupted explains how to resume a transfer that stopped midway.
+func (s *fileSender) interrupted() {
+	if s.progress == nil {
+		return
+	}
+	s.progress.end()
+	if acked := s.acked.Load(); acked < s.offer.Size {
+		fmt.Fprintf(s.out, "%s of %s was acknowledged; send the file again to resume.\n", formatByteSize(acked), formatByteSize(s.offer.Size))
+	}
+}
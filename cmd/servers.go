@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ravenpair/cli/internal/knownservers"
+	"github.com/ravenpair/cli/internal/transport"
+)
+
+var serversCmd = &cobra.Command{
+	Use:   "servers",
+	Short: "Manage trusted server certificates",
+	Long: `Manage the certificate fingerprints recorded for trust on first use.
+
+With --tofu, or tofu: true in the config file, the first connection to an
+https:// server records its certificate fingerprint in the known_servers
+file, which --known-servers relocates, instead of verifying it against the
+trusted CAs. Any later certificate that differs aborts the connection with a
+warning until the entry is forgotten.`,
+}
+
+var serversListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded server fingerprints",
+	Args:  cobra.NoArgs,
+	RunE:  runServersList,
+}
+
+var serversForgetCmd = &cobra.Command{
+	Use:   "forget <host>",
+	Short: "Forget the recorded fingerprint of a server",
+	Long: `Remove the recorded certificate fingerprint of a server so that the next
+connection records its current certificate. <host> is either host:port or a
+bare host name, which matches every port.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServersForget,
+}
+
+func init() {
+	rootCmd.AddCommand(serversCmd)
+	serversCmd.AddCommand(serversListCmd)
+	serversCmd.AddCommand(serversForgetCmd)
+}
+
+// knownServersStore opens the known_servers file, which can be relocated with
+// the known-servers setting.
+func knownServersStore() (*knownservers.Store, error) {
+	path := viper.GetString("known-servers")
+	if path == "" {
+		var err error
+		if path, err = knownservers.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	return knownservers.New(path), nil
+}
+
+// usesTLS reports whether the server at serverURL is reached over TLS.
+func usesTLS(serverURL string) bool {
+	u, err := url.Parse(serverURL)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "wss")
+}
+
+// serverHostPort returns the host:port the known_servers file uses for
+// serverURL, filling in the scheme's default port. It returns "" for a
+// unix:// address, which has no host.
+func serverHostPort(serverURL string) (string, error) {
+	if _, ok := transport.SocketPath(serverURL); ok {
+		return "", nil
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing server URL: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func runServersList(cmd *cobra.Command, args []string) error {
+	store, err := knownServersStore()
+	if err != nil {
+		return err
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		return err
+	}
+	hosts := make([]string, 0, len(entries))
+	for h := range entries {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	out := cmd.OutOrStdout()
+	for _, h := range hosts {
+		fmt.Fprintf(out, "%s %s\n", h, entries[h])
+	}
+	return nil
+}
+
+func runServersForget(cmd *cobra.Command, args []string) error {
+	store, err := knownServersStore()
+	if err != nil {
+		return err
+	}
+	removed, err := store.Forget(args[0])
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("no known server matches %s in %s", args[0], store.Path())
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Forgot %s (%d removed from %s)\n", args[0], removed, store.Path())
+	return nil
+}
@@ -0,0 +1,355 @@
+//go:build unix
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// outputDrainTimeout bounds how long share waits for the last output of a
+// shell that has exited, in case a background process keeps the terminal open.
+const outputDrainTimeout = time.Second
+
+// termEndTimeout bounds how long share tries to tell the pair that the shell
+// has exited.
+const termEndTimeout = 2 * time.Second
+
+var shareCmd = &cobra.Command{
+	Use:   "share [pair]",
+	Short: "Share your shell with a pair session",
+	Long: `Start your shell in a new pseudo-terminal and stream it to the given pair
+session, creating a new pair when none is given. Everyone in the session can
+watch it with "ravenpair attach <pair>".
+
+Only drivers may type into the shared terminal; keystrokes from everyone
+else are dropped. Participants are spectators unless named with --driver or
+--navigator, and roles can be changed while sharing with
+  ravenpair grant <user> write
+  ravenpair revoke <user>
+Every change is announced to the session. With --read-only nobody but you
+may type, and write access cannot be granted.
+
+--record saves the session as an asciicast v2 recording, which
+"ravenpair play" or asciinema can replay.
+
+The shell runs in your terminal as usual; share puts the terminal in raw mode
+so that every keystroke, Ctrl+C included, reaches the shell. Changes to the
+size of your terminal are passed on to the shell and to everyone attached.
+Sharing stops when the shell exits.
+
+--shell picks the command to run instead of $SHELL, for example
+  ravenpair share p1 --shell "tmux new -A -s pair"`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runShare,
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+	shareCmd.Flags().String("shell", "", "command to share (default $SHELL)")
+	shareCmd.Flags().Bool("read-only", false, "ignore keystrokes from the pair session; others can only watch")
+	shareCmd.Flags().StringSlice("driver", nil, "users who may type into the shell")
+	shareCmd.Flags().StringSlice("navigator", nil, "users who take part as navigators")
+	shareCmd.Flags().String("record", "", "also record the session to this asciicast v2 file")
+	shareCmd.Flags().String("name", "", "name of the pair session to create when no pair is given")
+	addSessionFlags(shareCmd)
+}
+
+// shellCommand returns the command line --shell asks for, falling back to
+// $SHELL and then /bin/sh.
+func shellCommand(cmd *cobra.Command) ([]string, error) {
+	line := stringSetting(cmd, "shell", "share.shell")
+	if line == "" {
+		line = os.Getenv("SHELL")
+	}
+	if line == "" {
+		line = "/bin/sh"
+	}
+	args, err := splitCommand(line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --shell: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid --shell: empty command")
+	}
+	return args, nil
+}
+
+func runShare(cmd *cobra.Command, args []string) error {
+	shellArgs, err := shellCommand(cmd)
+	if err != nil {
+		return err
+	}
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	roles, err := parseShareRoles(cmd, readOnly)
+	if err != nil {
+		return err
+	}
+
+	var pairID string
+	if len(args) == 1 {
+		pairID = args[0]
+	} else if pairID, err = createPair(stringSetting(cmd, "name", "pair.name")); err != nil {
+		return err
+	}
+
+	in, out, errOut := cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ln, err := listenControl(pairID)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	calls := make(chan controlCall)
+	go serveControl(ctx, ln, calls)
+
+	local := openLocalTerminal(in)
+	cols, rows := local.size()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+	rec, err := startRecording(cmd, cols, rows, strings.Join(shellArgs, " "))
+	if err != nil {
+		sess.Close(ports.CloseNormalClosure, "")
+		return err
+	}
+	defer stopRecording(cmd, rec)
+
+	shell := exec.Command(shellArgs[0], shellArgs[1:]...)
+	shell.Env = append(os.Environ(), "RAVENPAIR_PAIR="+pairID)
+	ptmx, err := pty.StartWithSize(shell, &pty.Winsize{Cols: cols, Rows: rows})
+	if err != nil {
+		sess.Close(ports.CloseNormalClosure, "")
+		return fmt.Errorf("starting %s: %w", shellArgs[0], err)
+	}
+	defer ptmx.Close()
+
+	mode := "read-write"
+	if readOnly {
+		mode = "read-only"
+	}
+	fmt.Fprintf(out, "Sharing %s with pair %s (%s). Exit the shell to stop sharing.\n", shellArgs[0], pairID, mode)
+	if err := local.makeRaw(); err != nil {
+		fmt.Fprintf(errOut, "share: cannot switch the terminal to raw mode: %v\n", err)
+	}
+	defer local.restore()
+
+	send := func(eventType string, payload interface{}) {
+		if err := sendEvent(ctx, sess, eventType, pairID, payload); err != nil {
+			fmt.Fprintf(errOut, "share: sending %s: %v%s", eventType, err, local.newline())
+		}
+	}
+	send(protocol.TypeTermStart, protocol.TermSizePayload{Cols: cols, Rows: rows, Shell: shellArgs[0]})
+	for user, role := range roles.byUser {
+		send(protocol.TypeTermRole, protocol.TermRolePayload{User: user, Role: role})
+	}
+	setRole := func(user, role string) error {
+		if err := roles.set(user, role); err != nil {
+			return err
+		}
+		send(protocol.TypeTermRole, protocol.TermRolePayload{User: user, Role: role})
+		fmt.Fprintf(errOut, "share: %s is now a %s%s", user, role, local.newline())
+		return nil
+	}
+
+	// The shell's output goes to the local terminal and to the session.
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := ptmx.Read(buf)
+			if n > 0 {
+				out.Write(buf[:n])
+				if rec != nil {
+					rec.Output(buf[:n])
+				}
+				send(protocol.TypeTermOutput, protocol.TermDataPayload{Data: buf[:n]})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// Local keystrokes always reach the shell. The copy ends with the
+	// process when stdin is a terminal.
+	go func() {
+		_, _ = io.Copy(ptmx, in)
+	}()
+	exited := make(chan int, 1)
+	go func() {
+		err := shell.Wait()
+		var ee *exec.ExitError
+		switch {
+		case err == nil:
+			exited <- 0
+		case errors.As(err, &ee):
+			exited <- ee.ExitCode()
+		default:
+			exited <- -1
+		}
+	}()
+
+	var resized chan os.Signal
+	if local != nil {
+		resized = make(chan os.Signal, 1)
+		signal.Notify(resized, syscall.SIGWINCH)
+		defer signal.Stop(resized)
+	}
+	resize := func() {
+		cols, rows = local.size()
+		if err := pty.Setsize(ptmx, &pty.Winsize{Cols: cols, Rows: rows}); err != nil {
+			fmt.Fprintf(errOut, "share: resizing terminal: %v%s", err, local.newline())
+		}
+		send(protocol.TypeTermResize, protocol.TermSizePayload{Cols: cols, Rows: rows})
+		if rec != nil {
+			rec.Resize(int(cols), int(rows))
+		}
+	}
+
+	// hangUp ends the shell when the session ends first.
+	hangUp := func() {
+		_ = shell.Process.Signal(syscall.SIGHUP)
+	}
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				hangUp()
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				if e.Type != ports.TextMessage {
+					continue
+				}
+				event, err := protocol.Decode(e.Data)
+				if err != nil {
+					continue
+				}
+				switch event.Type {
+				case protocol.TypeTermInput:
+					var p protocol.TermDataPayload
+					if roles.of(event.Sender) != protocol.RoleDriver || event.DecodePayload(&p) != nil {
+						continue
+					}
+					_, _ = ptmx.Write(p.Data)
+				case protocol.TypeJoin:
+					// Newcomers need the size to render the output, and to
+					// know whether they may type.
+					send(protocol.TypeTermResize, protocol.TermSizePayload{Cols: cols, Rows: rows})
+					if event.Sender != "" {
+						send(protocol.TypeTermRole, protocol.TermRolePayload{User: event.Sender, Role: roles.of(event.Sender)})
+					}
+				}
+			case ports.Closed:
+				hangUp()
+				local.restore()
+				if e.Local {
+					fmt.Fprintf(out, "Connection closed: %s\n", describeClose(e))
+					return nil
+				}
+				return serverClosed(errOut, e)
+			case ports.Error:
+				hangUp()
+				local.restore()
+				fmt.Fprintf(errOut, "connection error: %v\n", e.Err)
+				return e.Err
+			}
+		case call := <-calls:
+			call.reply <- setRole(call.req.User, call.req.Role)
+		case <-resized:
+			resize()
+		case code := <-exited:
+			select {
+			case <-outputDone:
+			case <-time.After(outputDrainTimeout):
+			}
+			// An interrupt cancels ctx before it ends the shell, and the
+			// pair must still hear that the shell is gone.
+			endCtx, cancelEnd := context.WithTimeout(context.Background(), termEndTimeout)
+			err := sendEvent(endCtx, sess, protocol.TypeTermEnd, pairID, protocol.TermEndPayload{ExitCode: code})
+			cancelEnd()
+			if err != nil {
+				fmt.Fprintf(errOut, "share: sending %s: %v%s", protocol.TypeTermEnd, err, local.newline())
+			}
+			local.restore()
+			fmt.Fprintf(out, "Shell exited with status %d. Closing connection...\n", code)
+			if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+				return err
+			}
+		case <-interrupted:
+			interrupted = nil
+			hangUp()
+		}
+	}
+}
+
+// shareRoles holds the roles of the participants of a shared terminal; those
+// not listed are spectators.
+type shareRoles struct {
+	byUser   map[string]string
+	readOnly bool
+}
+
+// parseShareRoles returns the roles given with --driver and --navigator.
+func parseShareRoles(cmd *cobra.Command, readOnly bool) (*shareRoles, error) {
+	r := &shareRoles{byUser: make(map[string]string), readOnly: readOnly}
+	for _, role := range []string{protocol.RoleDriver, protocol.RoleNavigator} {
+		users, _ := cmd.Flags().GetStringSlice(role)
+		for _, user := range users {
+			if err := r.set(user, role); err != nil {
+				return nil, fmt.Errorf("--%s %s: %w", role, user, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (r *shareRoles) of(user string) string {
+	if role, ok := r.byUser[user]; ok {
+		return role
+	}
+	return protocol.RoleSpectator
+}
+
+func (r *shareRoles) set(user, role string) error {
+	switch {
+	case user == "":
+		return errors.New("no user given")
+	case !protocol.ValidRole(role):
+		return fmt.Errorf("unknown role %q", role)
+	case role == protocol.RoleDriver && r.readOnly:
+		return errors.New("the terminal is shared read-only")
+	}
+	if role == protocol.RoleSpectator {
+		delete(r.byUser, user)
+	} else {
+		r.byUser[user] = role
+	}
+	return nil
+}
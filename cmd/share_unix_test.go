@@ -0,0 +1,403 @@
+//go:build unix
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ravenpair/cli/internal/asciicast"
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+// sentEvents decodes the events sent in a mock session.
+func sentEvents(t *testing.T, sess *mockSession) []protocol.Envelope {
+	t.Helper()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	var events []protocol.Envelope
+	for _, data := range sess.sent {
+		e, err := protocol.Decode(data)
+		if err != nil {
+			t.Fatalf("sent message is not an event: %q", data)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// termInput returns a term.input event from sender.
+func termInput(sender, keys string) ports.Message {
+	data, _ := json.Marshal(protocol.Envelope{
+		Type:    protocol.TypeTermInput,
+		Sender:  sender,
+		Payload: json.RawMessage(fmt.Sprintf(`{"data":%q}`, base64.StdEncoding.EncodeToString([]byte(keys)))),
+	})
+	return ports.Message{Type: ports.TextMessage, Data: data}
+}
+
+func TestShareCmd(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	sess := newLiveMockSession(
+		ports.Opened{},
+		termInput("bo", "ignored\n"),
+		termInput("ana", "hi\n"),
+	)
+	var gotPath string
+	setSvc(&mockAPIClient{
+		createPairFn: func(string) (int, []byte, error) {
+			return 201, []byte(`{"id":42,"name":"test-pair"}`), nil
+		},
+	}, &mockWSClient{
+		openFn: func(_ context.Context, wsURL string, _ ports.DialOptions) (ports.Session, error) {
+			gotPath = wsURL
+			return sess, nil
+		},
+	})
+
+	for name, value := range map[string]string{"shell": `sh -c 'read line; echo "got $line"; exit 3'`, "driver": "ana"} {
+		if err := shareCmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_ = shareCmd.Flags().Set("shell", "")
+		_ = shareCmd.Flags().Lookup("driver").Value.(pflag.SliceValue).Replace(nil)
+	})
+	buf := new(bytes.Buffer)
+	shareCmd.SetIn(new(bytes.Buffer))
+	shareCmd.SetOut(buf)
+	shareCmd.SetErr(new(bytes.Buffer))
+
+	if err := shareCmd.RunE(shareCmd, nil); err != nil {
+		t.Fatalf("share command failed: %v", err)
+	}
+
+	if !strings.HasSuffix(gotPath, "/ws/pairs/42") {
+		t.Errorf("expected the new pair's session path, got %s", gotPath)
+	}
+	events := sentEvents(t, sess)
+	if len(events) < 4 || events[0].Type != protocol.TypeTermStart || events[1].Type != protocol.TypeTermRole || events[len(events)-1].Type != protocol.TypeTermEnd {
+		t.Fatalf("expected term.start, term.role, output and term.end, got %+v", events)
+	}
+	var output []byte
+	for _, e := range events[2 : len(events)-1] {
+		var p protocol.TermDataPayload
+		if e.Type != protocol.TypeTermOutput || e.DecodePayload(&p) != nil {
+			t.Fatalf("unexpected event %+v", e)
+		}
+		output = append(output, p.Data...)
+	}
+	if !strings.Contains(string(output), "got hi") {
+		t.Errorf("expected the shell to read the driver's input only, got %q", output)
+	}
+	var end protocol.TermEndPayload
+	if err := events[len(events)-1].DecodePayload(&end); err != nil || end.ExitCode != 3 {
+		t.Errorf("expected exit status 3, got %+v (%v)", end, err)
+	}
+	if !strings.Contains(buf.String(), "got hi") || !strings.Contains(buf.String(), "Shell exited with status 3") {
+		t.Errorf("expected the shell's output locally, got: %s", buf.String())
+	}
+}
+
+func TestShareCmdTerminated(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	sess := newPairSession(t)
+	sess.events <- ports.Opened{}
+	if err := shareCmd.Flags().Set("shell", "sleep 30"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shareCmd.Flags().Set("shell", "") })
+	out := new(syncBuffer)
+	shareCmd.SetIn(new(bytes.Buffer))
+	shareCmd.SetOut(out)
+	shareCmd.SetErr(new(bytes.Buffer))
+	done := make(chan error, 1)
+	go func() { done <- shareCmd.RunE(shareCmd, []string{"p1"}) }()
+
+	eventually(t, "the shell", func() bool { return strings.Contains(out.String(), "Sharing sleep") })
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("share command failed: %v", err)
+	}
+	events := sentEvents(t, sess)
+	if len(events) == 0 || events[len(events)-1].Type != protocol.TypeTermEnd {
+		t.Errorf("expected term.end to be sent after SIGTERM, got %+v", events)
+	}
+}
+
+func TestShareCmdReadOnly(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	sess := newLiveMockSession(
+		ports.Opened{},
+		termInput("", "exit 7\n"),
+	)
+	setSvc(nil, &mockWSClient{
+		openFn: func(context.Context, string, ports.DialOptions) (ports.Session, error) {
+			return sess, nil
+		},
+	})
+
+	for name, value := range map[string]string{"shell": "sh -c 'sleep 0.5'", "read-only": "true"} {
+		if err := shareCmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_ = shareCmd.Flags().Set("shell", "")
+		_ = shareCmd.Flags().Set("read-only", "false")
+	})
+	shareCmd.SetIn(new(bytes.Buffer))
+	shareCmd.SetOut(new(bytes.Buffer))
+	shareCmd.SetErr(new(bytes.Buffer))
+
+	if err := shareCmd.RunE(shareCmd, []string{"p1"}); err != nil {
+		t.Fatalf("share command failed: %v", err)
+	}
+
+	events := sentEvents(t, sess)
+	var end protocol.TermEndPayload
+	if err := events[len(events)-1].DecodePayload(&end); err != nil || end.ExitCode != 0 {
+		t.Errorf("expected the guest's input to be ignored, got %+v (%v)", end, err)
+	}
+}
+
+func TestShareCmdGrantAndRevoke(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	sess := newPairSession(t)
+
+	if err := shareCmd.Flags().Set("shell", `sh -c 'read line; echo "got $line"'`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shareCmd.Flags().Set("shell", "") })
+	buf := new(bytes.Buffer)
+	shareCmd.SetIn(new(bytes.Buffer))
+	shareCmd.SetOut(buf)
+	shareCmd.SetErr(new(bytes.Buffer))
+	done := make(chan error, 1)
+	go func() { done <- shareCmd.RunE(shareCmd, []string{"p1"}) }()
+
+	// Wait until the share handled the first input and listens for grants.
+	sess.events <- ports.Opened{}
+	sess.events <- termInput("bo", "before\n")
+	socket := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), fmt.Sprintf("ravenpair-%d", os.Getuid()), "share-p1.sock")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(socket); err == nil && len(sess.events) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("share did not start")
+		}
+	}
+
+	grantCmd.SetOut(new(bytes.Buffer))
+	if err := grantCmd.RunE(grantCmd, []string{"bo", "owner"}); err == nil {
+		t.Error("expected an unknown role to be refused")
+	}
+	if err := grantCmd.RunE(grantCmd, []string{"bo", "write"}); err != nil {
+		t.Fatalf("grant failed: %v", err)
+	}
+	sess.events <- termInput("bo", "after\n")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("share command failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("share did not end")
+	}
+	if !strings.Contains(buf.String(), "got after") {
+		t.Errorf("expected input once bo was made a driver, got: %s", buf.String())
+	}
+	var role protocol.TermRolePayload
+	for _, e := range sentEvents(t, sess) {
+		if e.Type == protocol.TypeTermRole {
+			_ = e.DecodePayload(&role)
+		}
+	}
+	if role.User != "bo" || role.Role != protocol.RoleDriver {
+		t.Errorf("expected the grant to be announced, got %+v", role)
+	}
+
+	revokeCmd.SetOut(new(bytes.Buffer))
+	if err := revokeCmd.RunE(revokeCmd, []string{"bo"}); err == nil {
+		t.Error("expected revoke to fail once the share ended")
+	}
+}
+
+func TestAttachCmdReadOnly(t *testing.T) {
+	event := func(eventType string, payload interface{}) ports.Message {
+		data, _ := protocol.Encode(eventType, "p1", payload)
+		return ports.Message{Type: ports.TextMessage, Data: data}
+	}
+	sess := newMockSession(
+		ports.Opened{},
+		event(protocol.TypeTermStart, protocol.TermSizePayload{Cols: 80, Rows: 24, Shell: "bash"}),
+		event(protocol.TypeTermRole, protocol.TermRolePayload{User: "bo", Role: protocol.RoleNavigator}),
+		event(protocol.TypeTermOutput, protocol.TermDataPayload{Data: []byte("$ ls\r\nREADME.md\r\n")}),
+		event(protocol.TypeTermEnd, protocol.TermEndPayload{ExitCode: 0}),
+	)
+	setSvc(nil, &mockWSClient{
+		openFn: func(context.Context, string, ports.DialOptions) (ports.Session, error) {
+			return sess, nil
+		},
+	})
+
+	record := filepath.Join(t.TempDir(), "session.cast")
+	for name, value := range map[string]string{"read-only": "true", "record": record} {
+		if err := attachCmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_ = attachCmd.Flags().Set("read-only", "false")
+		_ = attachCmd.Flags().Set("record", "")
+	})
+	buf := new(bytes.Buffer)
+	attachCmd.SetIn(strings.NewReader("typed\n"))
+	attachCmd.SetOut(buf)
+	attachCmd.SetErr(new(bytes.Buffer))
+
+	if err := attachCmd.RunE(attachCmd, []string{"p1"}); err != nil {
+		t.Fatalf("attach command failed: %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{"Watching pair p1", "started sharing a 80x24 terminal", "made bo a navigator", "README.md\r\n", "stopped sharing the terminal (exit status 0)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output, got: %s", want, got)
+		}
+	}
+	if len(sess.sent) != 0 {
+		t.Errorf("expected nothing to be sent in read-only mode, got %q", sess.sent)
+	}
+
+	f, err := os.Open(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, recorded, err := asciicast.Read(f)
+	if err != nil {
+		t.Fatalf("reading the recording: %v", err)
+	}
+	if len(recorded) != 2 || recorded[0].Code != asciicast.Resize || recorded[0].Data != "80x24" || recorded[1].Data != "$ ls\r\nREADME.md\r\n" {
+		t.Errorf("unexpected recording %+v", recorded)
+	}
+}
+
+func TestAttachCmdRecordsNothingWhenTheDialFails(t *testing.T) {
+	setSvc(nil, &mockWSClient{
+		openFn: func(context.Context, string, ports.DialOptions) (ports.Session, error) {
+			return nil, errors.New("connection refused")
+		},
+	})
+	record := filepath.Join(t.TempDir(), "session.cast")
+	if err := attachCmd.Flags().Set("record", record); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = attachCmd.Flags().Set("record", "") })
+	buf := new(bytes.Buffer)
+	attachCmd.SetIn(strings.NewReader(""))
+	attachCmd.SetOut(buf)
+	attachCmd.SetErr(new(bytes.Buffer))
+
+	if err := attachCmd.RunE(attachCmd, []string{"p1"}); err == nil {
+		t.Fatal("expected the attach command to fail")
+	}
+	if _, err := os.Stat(record); !os.IsNotExist(err) {
+		t.Errorf("expected no recording, got %v", err)
+	}
+	if strings.Contains(buf.String(), "Recording saved") {
+		t.Errorf("unexpected output: %s", buf)
+	}
+}
+
+func TestAttachCmdSendsKeystrokes(t *testing.T) {
+	sess := newPairSession(t)
+	sess.events <- ports.Opened{}
+
+	buf := new(bytes.Buffer)
+	attachCmd.SetIn(strings.NewReader("ls\r\x1dignored"))
+	attachCmd.SetOut(buf)
+	attachCmd.SetErr(new(bytes.Buffer))
+
+	if err := attachCmd.RunE(attachCmd, []string{"p1"}); err != nil {
+		t.Fatalf("attach command failed: %v", err)
+	}
+
+	events := sentEvents(t, sess)
+	var p protocol.TermDataPayload
+	if len(events) != 1 || events[0].Type != protocol.TypeTermInput || events[0].DecodePayload(&p) != nil || string(p.Data) != "ls\r" {
+		t.Errorf("expected the keys typed before Ctrl+], got %+v", events)
+	}
+	if !strings.Contains(buf.String(), "Detached.") {
+		t.Errorf("expected to detach, got: %s", buf.String())
+	}
+}
+
+func TestPlayCmd(t *testing.T) {
+	record := filepath.Join(t.TempDir(), "session.cast")
+	cast := `{"version":2,"width":80,"height":24}
+[0.5,"o","hello "]
+[1.0,"r","100x30"]
+[3600,"o","world"]
+`
+	if err := os.WriteFile(record, []byte(cast), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := playCmd.Flags().Set("idle-time-limit", "10ms"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = playCmd.Flags().Set("idle-time-limit", "0s") })
+	buf := new(bytes.Buffer)
+	playCmd.SetIn(new(bytes.Buffer))
+	playCmd.SetOut(buf)
+	playCmd.SetErr(new(bytes.Buffer))
+
+	if err := playCmd.RunE(playCmd, []string{record}); err != nil {
+		t.Fatalf("play command failed: %v", err)
+	}
+	if buf.String() != "hello world\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestPlayerControls(t *testing.T) {
+	keys := make(chan byte)
+	buf := new(bytes.Buffer)
+	p := &player{out: buf, speed: 1, keys: keys}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.play(context.Background(), []asciicast.Event{
+			{Time: time.Hour, Code: asciicast.Output, Data: "one"},
+			{Time: 2 * time.Hour, Code: asciicast.Output, Data: "two"},
+		})
+	}()
+
+	keys <- '+'
+	keys <- ' '
+	keys <- '.'
+	keys <- 'q'
+	<-done
+	if buf.String() != "one" || p.speed != 2 {
+		t.Errorf("expected one frame at double speed, got %q at %g", buf.String(), p.speed)
+	}
+}
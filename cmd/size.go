@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var byteSizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "512", "64KiB", "10MB" or "1G". Single
+// letter suffixes are binary. An empty string is zero.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	in := s
+	scale := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a number of bytes with an optional unit such as KiB or MB", s)
+	}
+	if n > math.MaxInt64/scale {
+		return 0, fmt.Errorf("invalid size %q: too large", in)
+	}
+	return n * scale, nil
+}
+
+// byteSizeFlag returns the value of a size flag registered as a string.
+func byteSizeFlag(cmd *cobra.Command, name string) (int64, error) {
+	s, _ := cmd.Flags().GetString(name)
+	n, err := parseByteSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %w", name, err)
+	}
+	return n, nil
+}
+
+// formatByteSize formats a size with the largest binary unit that keeps it
+// at or above one, such as "512 B" or "1.5 MiB".
+func formatByteSize(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n)/(1<<10), "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1<<10 {
+			break
+		}
+		value, unit = value/(1<<10), next
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ravenpair/cli/internal/dirsync"
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <dir>",
+	Short: "Keep a directory in sync with a pair",
+	Long: `Keep a directory in sync between everyone in the pair session who runs
+"ravenpair sync" on it.
+
+Files are compared when syncing starts; then each change is sent as it is
+saved, as an rsync-style delta against the partner's copy. Files that the
+directory's .gitignore files exclude, the .git directory and files larger
+than 8 MiB are left out.
+
+When a file is changed on both sides before the changes meet, neither is
+overwritten: the partner's version is saved next to yours as
+<file>.ravenpair-conflict-<user>, and the conflict is reported. The next
+change you save to the file, once you have merged them, is sent as usual.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSync,
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().String("pair", "", "pair session to sync through")
+	addSessionFlags(syncCmd)
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	pairID := stringSetting(cmd, "pair", "sync.pair")
+	if pairID == "" {
+		return errors.New("a pair is required: use --pair")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	path := pairPath(cmd, pairID)
+	sess, err := dialSession(ctx, cmd, path)
+	if err != nil {
+		return err
+	}
+	if err := awaitOpened(cmd, sess); err != nil {
+		return err
+	}
+
+	syncer, err := dirsync.New(ctx, sess, pairID, args[0], randomID())
+	if err != nil {
+		sess.Close(ports.CloseNormalClosure, "")
+		return err
+	}
+	defer syncer.Close()
+	out := cmd.OutOrStdout()
+	syncer.OnReport = func(r dirsync.Report) { printSyncReport(out, r) }
+	n := syncer.Start()
+	fmt.Fprintf(out, "Syncing %s (%d file(s)) with pair %s. Press Ctrl+C to stop.\n", args[0], n, pairID)
+
+	interrupted := ctx.Done()
+	for {
+		select {
+		case ev, ok := <-sess.Events():
+			if !ok {
+				return nil
+			}
+			switch e := ev.(type) {
+			case ports.Message:
+				syncer.Handle(e)
+			case ports.Closed, ports.Error:
+				return sessionEnded(cmd, out, ev, nil)
+			}
+		case paths := <-syncer.Changes():
+			syncer.Local(paths)
+		case <-interrupted:
+			interrupted = nil
+			syncer.Close()
+			if err := interruptSession(out, sess); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// printSyncReport tells what happened to a synced file.
+func printSyncReport(out io.Writer, r dirsync.Report) {
+	user := senderName(r.User)
+	switch r.Action {
+	case dirsync.Published:
+		if r.Deleted {
+			fmt.Fprintf(out, "Sent the deletion of %s\n", r.Path)
+		} else {
+			fmt.Fprintf(out, "Sent %s\n", r.Path)
+		}
+	case dirsync.Applied:
+		if r.Deleted {
+			fmt.Fprintf(out, "%s deleted %s\n", user, r.Path)
+		} else {
+			fmt.Fprintf(out, "%s updated %s\n", user, r.Path)
+		}
+	case dirsync.Conflicted:
+		if r.Deleted {
+			fmt.Fprintf(out, "CONFLICT: %s deleted %s, which changed here too; your version is kept\n", user, r.Path)
+		} else {
+			fmt.Fprintf(out, "CONFLICT: %s changed here and by %s; their version is in %s\n", r.Path, user, r.ConflictPath)
+		}
+	case dirsync.Skipped:
+		fmt.Fprintf(out, "Skipped %s: %v\n", r.Path, r.Err)
+	case dirsync.Failed:
+		fmt.Fprintf(out, "Could not sync %s: %v\n", r.Path, r.Err)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build unix
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/ravenpair/cli/internal/asciicast"
+)
+
+// Size of the shared terminal when the local one is unknown.
+const (
+	defaultTermCols = 80
+	defaultTermRows = 24
+)
+
+// localTerminal is the user's terminal, when stdin is one. Its methods do
+// nothing on a nil *localTerminal, so callers need not check. mu guards
+// state, as output goroutines call newline while the main loop switches
+// modes.
+type localTerminal struct {
+	f     *os.File
+	mu    sync.Mutex
+	state *term.State
+}
+
+// openLocalTerminal returns the terminal r reads from, or nil if r is not a
+// terminal.
+func openLocalTerminal(r io.Reader) *localTerminal {
+	f, ok := r.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	return &localTerminal{f: f}
+}
+
+// size returns the terminal's size, or the default size when it is unknown.
+func (t *localTerminal) size() (cols, rows uint16) {
+	if t != nil {
+		if w, h, err := term.GetSize(int(t.f.Fd())); err == nil && w > 0 && h > 0 {
+			return uint16(w), uint16(h)
+		}
+	}
+	return defaultTermCols, defaultTermRows
+}
+
+// makeRaw puts the terminal in raw mode, so that keystrokes are passed on as
+// typed, Ctrl+C included, instead of being handled locally.
+func (t *localTerminal) makeRaw() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state != nil {
+		return nil
+	}
+	state, err := term.MakeRaw(int(t.f.Fd()))
+	if err != nil {
+		return err
+	}
+	t.state = state
+	return nil
+}
+
+// restore undoes makeRaw.
+func (t *localTerminal) restore() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == nil {
+		return
+	}
+	_ = term.Restore(int(t.f.Fd()), t.state)
+	t.state = nil
+}
+
+// newline ends a notice printed between terminal output; raw mode needs an
+// explicit carriage return.
+func (t *localTerminal) newline() string {
+	if t == nil {
+		return "\n"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state != nil {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// startRecording creates the file given with --record, if any, for a
+// terminal of the given size.
+func startRecording(cmd *cobra.Command, cols, rows uint16, command string) (*asciicast.Writer, error) {
+	path, _ := cmd.Flags().GetString("record")
+	if path == "" {
+		return nil, nil
+	}
+	rec, err := asciicast.Create(path, asciicast.Header{
+		Width:   int(cols),
+		Height:  int(rows),
+		Command: command,
+		Env:     map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recording: %w", err)
+	}
+	return rec, nil
+}
+
+// stopRecording closes the recording started by startRecording.
+func stopRecording(cmd *cobra.Command, rec *asciicast.Writer) {
+	if rec == nil {
+		return
+	}
+	path, _ := cmd.Flags().GetString("record")
+	if err := rec.Close(); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "recording %s: %v\n", path, err)
+		return
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Recording saved to %s\n", path)
+}
@@ -1,30 +1,84 @@
 package http
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+
+	"github.com/ravenpair/cli/internal/transport"
 )
 
 // Client is the HTTP adapter that implements ports.APIClient.
 type Client struct {
 	baseURL    string
 	token      string
+	header     http.Header
+	transport  *http.Transport
 	httpClient *http.Client
 }
 
-// New returns a new HTTP Client adapter.
-func New(baseURL, token string) *Client {
-	return &Client{
-		baseURL:    strings.TrimRight(baseURL, "/"),
-		token:      token,
-		httpClient: &http.Client{},
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithTLSConfig makes the Client verify the server and present client
+// certificates according to cfg.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.transport.TLSClientConfig = cfg
+	}
+}
+
+// WithProxy routes requests through the proxy chosen by proxy.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(c *Client) {
+		c.transport.Proxy = proxy
+	}
+}
+
+// WithDialContext replaces how connections to the server are established, for
+// example to reach it over a Unix domain socket.
+func WithDialContext(dial transport.DialFunc) Option {
+	return func(c *Client) {
+		c.transport.DialContext = dial
+	}
+}
+
+// WithHeader sends the given headers with every request, overriding the
+// defaults. A Host header replaces the request's Host.
+func WithHeader(h http.Header) Option {
+	return func(c *Client) {
+		c.header = h
+	}
+}
+
+// New returns a new HTTP Client adapter. baseURL may be a unix:// address, in
+// which case requests go over that Unix domain socket.
+func New(baseURL, token string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		token:     token,
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	if socket, ok := transport.SocketPath(baseURL); ok {
+		c.baseURL = "http://" + transport.UnixSocketHost
+		c.transport.DialContext = transport.UnixDialer(socket)
+		c.transport.Proxy = nil
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.httpClient = &http.Client{Transport: c.transport}
+	return c
 }
 
 func (c *Client) do(method, path string, body io.Reader) (int, []byte, error) {
-	url := c.baseURL + path
+	url, err := transport.JoinPath(c.baseURL, path)
+	if err != nil {
+		return 0, nil, err
+	}
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -38,6 +92,14 @@ func (c *Client) do(method, path string, body io.Reader) (int, []byte, error) {
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
+	for name, values := range c.header {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return 0, nil, fmt.Errorf("sending request to %s: %w", url, err)
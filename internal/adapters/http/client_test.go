@@ -2,8 +2,10 @@ package http
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 )
 
@@ -110,3 +112,57 @@ func TestAuthorizationHeader(t *testing.T) {
 		t.Errorf("expected 'Bearer secret-token', got %q", gotAuth)
 	}
 }
+
+func TestUnixSocketBaseURL(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ravenpair.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	c := New("unix://"+socket, "")
+	code, body, err := c.GetStatus()
+	if err != nil {
+		t.Fatalf("GetStatus error: %v", err)
+	}
+	if code != http.StatusOK || string(body) != `{"status":"ok"}` {
+		t.Errorf("unexpected response: %d %s", code, body)
+	}
+}
+
+func TestBasePathAndHeaders(t *testing.T) {
+	var gotPath, gotHost, gotTeam string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotHost = r.Host
+		gotTeam = r.Header.Get("X-Team")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	header := http.Header{"X-Team": {"ravens"}, "Host": {"tools.corp"}}
+	c := New(srv.URL+"/ravenpair/", "", WithHeader(header))
+	if _, _, err := c.ListPairs(); err != nil {
+		t.Fatalf("ListPairs error: %v", err)
+	}
+	if gotPath != "/ravenpair/api/pairs" {
+		t.Errorf("expected path under the prefix, got %q", gotPath)
+	}
+	if gotHost != "tools.corp" {
+		t.Errorf("expected Host override, got %q", gotHost)
+	}
+	if gotTeam != "ravens" {
+		t.Errorf("expected X-Team header, got %q", gotTeam)
+	}
+}
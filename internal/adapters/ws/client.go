@@ -2,59 +2,185 @@ package ws
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/transport"
+)
+
+const (
+	defaultPingInterval = 30 * time.Second
+	defaultCloseTimeout = 5 * time.Second
+	defaultQueueSize    = 256
 )
 
 // Client is the WebSocket adapter that implements ports.WSClient.
-type Client struct{}
+type Client struct {
+	tlsConfig *tls.Config
+	proxy     func(*http.Request) (*url.URL, error)
+	dial      transport.DialFunc
+	header    http.Header
+	origin    string
+
+	pingInterval time.Duration
+}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithTLSConfig makes the Client verify the server and present client
+// certificates according to cfg.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithProxy routes the WebSocket handshake through the proxy chosen by proxy.
+// By default the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// are honoured, as they are by the HTTP adapter.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(c *Client) {
+		c.proxy = proxy
+	}
+}
+
+// WithDialContext replaces how connections to the server are established, for
+// example to reach it over a Unix domain socket.
+func WithDialContext(dial transport.DialFunc) Option {
+	return func(c *Client) {
+		c.dial = dial
+	}
+}
+
+// WithHeader sends the given headers with every handshake, overriding those
+// passed to Open. A Host header replaces the request's Host.
+func WithHeader(h http.Header) Option {
+	return func(c *Client) {
+		c.header = h
+	}
+}
+
+// WithOrigin sets the Origin header of the handshake.
+func WithOrigin(origin string) Option {
+	return func(c *Client) {
+		c.origin = origin
+	}
+}
+
+// WithPingInterval sets how often keep-alive pings are sent; each answer is
+// reported as a ports.Pong event. Zero disables pings.
+func WithPingInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.pingInterval = d
+	}
+}
 
 // New returns a new WebSocket Client adapter.
-func New() *Client {
-	return &Client{}
+func New(opts ...Option) *Client {
+	c := &Client{proxy: http.ProxyFromEnvironment, pingInterval: defaultPingInterval}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// Dial connects to wsURL, sets the provided headers, and calls onMessage for
-// each message received. It blocks until ctx is cancelled or the connection is
-// closed by the server.
-func (c *Client) Dial(ctx context.Context, wsURL string, headers map[string]string, onMessage ports.MessageHandler) error {
+// Open connects to wsURL, sending opts.Headers with the handshake, and returns
+// the established session.
+func (c *Client) Open(ctx context.Context, wsURL string, opts ports.DialOptions) (ports.Session, error) {
 	h := http.Header{}
-	for k, v := range headers {
+	for k, v := range opts.Headers {
 		h.Set(k, v)
 	}
+	if c.origin != "" {
+		h.Set("Origin", c.origin)
+	}
+	for k, v := range c.header {
+		h[k] = v
+	}
 
-	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
-	conn, _, err := dialer.DialContext(ctx, wsURL, h)
+	dialer := websocket.Dialer{
+		HandshakeTimeout:  10 * time.Second,
+		TLSClientConfig:   c.tlsConfig,
+		Proxy:             c.proxy,
+		NetDialContext:    c.dial,
+		Subprotocols:      opts.Subprotocols,
+		EnableCompression: opts.Compression,
+	}
+	conn, resp, err := dialer.DialContext(ctx, wsURL, h)
 	if err != nil {
-		return fmt.Errorf("WebSocket dial: %w", err)
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			err = &ports.HandshakeError{StatusCode: resp.StatusCode, Err: err}
+		}
+		return nil, fmt.Errorf("WebSocket dial: %w", err)
 	}
-	defer conn.Close()
 
-	errCh := make(chan error, 1)
-	go func() {
-		for {
-			msgType, data, readErr := conn.ReadMessage()
-			if readErr != nil {
-				errCh <- readErr
-				return
-			}
-			onMessage(msgType, data)
+	opened := ports.Opened{Subprotocol: conn.Subprotocol(), Extensions: extensionNames(resp.Header)}
+	if err := checkNegotiation(opts, opened); err != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseMandatoryExtension, "")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+		conn.Close()
+		return nil, err
+	}
+	if opts.Compression && opts.CompressionLevel != nil {
+		if err := conn.SetCompressionLevel(*opts.CompressionLevel); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("compression level %d: %w", *opts.CompressionLevel, err)
 		}
-	}()
-
-	select {
-	case <-ctx.Done():
-		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-		_ = conn.WriteMessage(websocket.CloseMessage, msg)
-		return nil
-	case err := <-errCh:
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-			return nil
+	}
+
+	closeTimeout := opts.CloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = defaultCloseTimeout
+	}
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if opts.MaxMessageSize > 0 {
+		conn.SetReadLimit(opts.MaxMessageSize)
+	}
+	return newSession(conn, opened, sessionConfig{
+		pingInterval:    c.pingInterval,
+		closeTimeout:    closeTimeout,
+		maxMessageSize:  opts.MaxMessageSize,
+		streamThreshold: opts.StreamThreshold,
+		queueSize:       queueSize,
+		overflow:        opts.Overflow,
+	}), nil
+}
+
+// extensionNames lists the extensions accepted in the handshake response,
+// without their parameters.
+func extensionNames(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
 		}
-		return err
 	}
+	return names
+}
+
+// checkNegotiation fails when the server ignored a required subprotocol or
+// extension.
+func checkNegotiation(opts ports.DialOptions, opened ports.Opened) error {
+	if len(opts.Subprotocols) > 0 && opened.Subprotocol == "" {
+		return fmt.Errorf("%w: server accepted none of the subprotocols %s",
+			ports.ErrNegotiation, strings.Join(opts.Subprotocols, ", "))
+	}
+	if opts.Compression && !slices.Contains(opened.Extensions, "permessage-deflate") {
+		return fmt.Errorf("%w: server did not accept permessage-deflate compression", ports.ErrNegotiation)
+	}
+	return nil
 }
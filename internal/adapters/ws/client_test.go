@@ -0,0 +1,363 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+// newTestServer starts a WebSocket server running handler for each connection
+// and returns its ws:// URL.
+func newTestServer(t *testing.T, handler func(*websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// nextEvent waits for the next event of sess.
+func nextEvent(t *testing.T, sess ports.Session) ports.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-sess.Events():
+		if !ok {
+			t.Fatal("event stream closed unexpectedly")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return nil
+}
+
+func TestSessionEchoAndServerClose(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(msgType, data)
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "bye"))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := nextEvent(t, sess).(ports.Opened); !ok {
+		t.Fatal("expected Opened as the first event")
+	}
+
+	if err := sess.Send(context.Background(), ports.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg, ok := nextEvent(t, sess).(ports.Message)
+	if !ok || msg.Type != ports.TextMessage || string(msg.Data) != "hello" {
+		t.Fatalf("expected echoed message, got %#v", msg)
+	}
+
+	closed, ok := nextEvent(t, sess).(ports.Closed)
+	if !ok {
+		t.Fatal("expected Closed event")
+	}
+	if closed.Code != websocket.ClosePolicyViolation || closed.Reason != "bye" || closed.Local {
+		t.Errorf("unexpected close: %+v", closed)
+	}
+	if _, ok := <-sess.Events(); ok {
+		t.Error("expected the event stream to be closed after Closed")
+	}
+}
+
+func TestSessionLocalClose(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	nextEvent(t, sess)
+
+	if err := sess.Close(ports.CloseNormalClosure, "done"); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	closed, ok := nextEvent(t, sess).(ports.Closed)
+	if !ok || !closed.Local || closed.Code != ports.CloseNormalClosure {
+		t.Errorf("expected the server to acknowledge the close, got %#v", closed)
+	}
+}
+
+func TestSessionCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		<-release // never reads, so the close frame is not answered
+	})
+	defer close(release)
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{CloseTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	nextEvent(t, sess)
+
+	start := time.Now()
+	if err := sess.Close(ports.CloseNormalClosure, ""); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	closed, ok := nextEvent(t, sess).(ports.Closed)
+	if !ok || closed.Code != ports.CloseAbnormalClosure {
+		t.Errorf("expected an abnormal close after the timeout, got %#v", closed)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Close gave up after %v, before the timeout", elapsed)
+	}
+}
+
+func TestSessionDroppedConnection(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		conn.UnderlyingConn().Close() // no closing handshake
+	})
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	nextEvent(t, sess)
+	if ev := nextEvent(t, sess); !isError(ev) {
+		t.Errorf("expected a dropped connection to end with Error, got %#v", ev)
+	}
+}
+
+func isError(ev ports.Event) bool {
+	_, ok := ev.(ports.Error)
+	return ok
+}
+
+func TestSessionSendHonoursCancellation(t *testing.T) {
+	release := make(chan struct{})
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		<-release // never reads, so writes eventually block
+	})
+	defer close(release)
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	nextEvent(t, sess)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	data := make([]byte, 1<<20)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if err := sess.Send(ctx, ports.BinaryMessage, data); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Send to fail with context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send ignored the cancellation")
+	}
+	if err := sess.Send(ctx, ports.TextMessage, []byte("late")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a Send with a cancelled context to fail, got %v", err)
+	}
+}
+
+func TestOpenHandshakeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := New().Open(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), ports.DialOptions{})
+	var he *ports.HandshakeError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a HandshakeError with 401, got %v", err)
+	}
+}
+
+func TestSessionPong(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	sess, err := New(WithPingInterval(10*time.Millisecond)).Open(context.Background(), url, ports.DialOptions{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sess.Close(ports.CloseNormalClosure, "")
+	nextEvent(t, sess)
+
+	if _, ok := nextEvent(t, sess).(ports.Pong); !ok {
+		t.Error("expected a Pong event")
+	}
+}
+
+func TestOpenSendsHeaders(t *testing.T) {
+	got := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	c := New(WithOrigin("https://tools.corp"), WithHeader(http.Header{"X-Team": {"ravens"}}))
+	sess, err := c.Open(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"),
+		ports.DialOptions{Headers: map[string]string{"Authorization": "Bearer tok"}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sess.Close(ports.CloseNormalClosure, "")
+
+	h := <-got
+	if h.Get("Authorization") != "Bearer tok" || h.Get("Origin") != "https://tools.corp" || h.Get("X-Team") != "ravens" {
+		t.Errorf("unexpected handshake headers: %v", h)
+	}
+}
+
+func TestSessionStreamsLargeMessages(t *testing.T) {
+	large := strings.Repeat("x", 1000)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("small"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte(large))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("after"))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{StreamThreshold: 100})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sess.Close(ports.CloseNormalClosure, "")
+	nextEvent(t, sess)
+
+	if msg, ok := nextEvent(t, sess).(ports.Message); !ok || string(msg.Data) != "small" {
+		t.Fatalf("expected a buffered message, got %#v", msg)
+	}
+	stream, ok := nextEvent(t, sess).(ports.Stream)
+	if !ok || stream.Type != ports.BinaryMessage {
+		t.Fatalf("expected a binary stream, got %#v", stream)
+	}
+	data, err := io.ReadAll(stream.Body)
+	if err != nil || string(data) != large {
+		t.Fatalf("unexpected stream body (%d bytes): %v", len(data), err)
+	}
+	if msg, ok := nextEvent(t, sess).(ports.Message); !ok || string(msg.Data) != "after" {
+		t.Fatalf("expected the next message after the stream, got %#v", msg)
+	}
+}
+
+func TestSessionMaxMessageSize(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.BinaryMessage, make([]byte, 1000))
+		_, _, _ = conn.ReadMessage()
+	})
+
+	sess, err := New().Open(context.Background(), url, ports.DialOptions{MaxMessageSize: 100})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	nextEvent(t, sess)
+
+	ev, ok := nextEvent(t, sess).(ports.Error)
+	if !ok || !errors.Is(ev.Err, ports.ErrMessageTooBig) {
+		t.Errorf("expected ErrMessageTooBig, got %#v", ev)
+	}
+}
+
+func TestOpenNegotiatesCompressionAndSubprotocol(t *testing.T) {
+	upgrader := websocket.Upgrader{EnableCompression: true, Subprotocols: []string{"ravenpair.v2"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(msgType, data)
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	level := 9
+	sess, err := New().Open(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), ports.DialOptions{
+		Subprotocols:     []string{"ravenpair.v3", "ravenpair.v2"},
+		Compression:      true,
+		CompressionLevel: &level,
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sess.Close(ports.CloseNormalClosure, "")
+
+	opened, ok := nextEvent(t, sess).(ports.Opened)
+	if !ok || opened.Subprotocol != "ravenpair.v2" || len(opened.Extensions) != 1 || opened.Extensions[0] != "permessage-deflate" {
+		t.Fatalf("unexpected negotiation: %#v", opened)
+	}
+
+	payload := strings.Repeat(`{"doc":"snapshot"}`, 100)
+	if err := sess.Send(context.Background(), ports.TextMessage, []byte(payload)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if msg, ok := nextEvent(t, sess).(ports.Message); !ok || string(msg.Data) != payload {
+		t.Errorf("compressed round trip failed: %#v", msg)
+	}
+}
+
+func TestOpenFailsWhenNegotiationIsRefused(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, _ = conn.ReadMessage()
+	})
+
+	for name, opts := range map[string]ports.DialOptions{
+		"subprotocol": {Subprotocols: []string{"ravenpair.v2"}},
+		"compression": {Compression: true},
+	} {
+		_, err := New().Open(context.Background(), url, opts)
+		if !errors.Is(err, ports.ErrNegotiation) {
+			t.Errorf("%s: expected ErrNegotiation, got %v", name, err)
+		}
+	}
+}
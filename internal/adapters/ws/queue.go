@@ -0,0 +1,188 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+// eventQueue decouples the read loop from the consumer of Session.Events. At
+// most size messages wait in it; what happens beyond that is decided by the
+// overflow policy. Lifecycle events bypass the limit and are never dropped.
+type eventQueue struct {
+	out    chan ports.Event
+	size   int
+	policy ports.OverflowPolicy
+
+	// released is closed by release, after which the pump waits at most
+	// grace for the consumer; gone is closed once the pump has given up.
+	released chan struct{}
+	grace    time.Duration
+	gone     chan struct{}
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	items     []ports.Event
+	messages  int // queued Message events
+	closed    bool
+	abandoned bool
+	received  uint64
+	dropped   uint64
+}
+
+func newEventQueue(size int, policy ports.OverflowPolicy) *eventQueue {
+	q := &eventQueue{
+		out:      make(chan ports.Event),
+		size:     size,
+		policy:   policy,
+		released: make(chan struct{}),
+		gone:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.pump()
+	return q
+}
+
+// push queues ev. It returns false when a message overflowed the queue under
+// the OverflowDisconnect policy.
+func (q *eventQueue) push(ev ports.Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.abandoned {
+		q.discard(ev)
+		return true
+	}
+	switch ev.(type) {
+	case ports.Message:
+		q.received++
+		for q.messages >= q.size && !q.abandoned {
+			switch q.policy {
+			case ports.OverflowDropNewest:
+				q.dropped++
+				return true
+			case ports.OverflowDropOldest:
+				q.dropOldest()
+			case ports.OverflowDisconnect:
+				q.dropped++
+				return false
+			default:
+				q.cond.Wait()
+			}
+		}
+		if q.abandoned {
+			q.dropped++
+			return true
+		}
+		q.messages++
+	case ports.Stream:
+		q.received++
+	}
+
+	q.items = append(q.items, ev)
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *eventQueue) dropOldest() {
+	for i, ev := range q.items {
+		if _, ok := ev.(ports.Message); ok {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.messages--
+			q.dropped++
+			return
+		}
+	}
+}
+
+// discard counts an event pushed after the consumer was given up on.
+func (q *eventQueue) discard(ev ports.Event) {
+	switch ev.(type) {
+	case ports.Message, ports.Stream:
+		q.received++
+		q.dropped++
+	}
+}
+
+// release lets the queue give up on a consumer that no longer reads it, so
+// that neither the pump nor the read loop waits for it forever: from now
+// on, an event the consumer does not take within grace is discarded with
+// everything after it. It is called when the session is closed.
+func (q *eventQueue) release(grace time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-q.released:
+	default:
+		q.grace = grace
+		close(q.released)
+	}
+}
+
+// abandon discards inFlight, the queued events and, through push, the rest.
+func (q *eventQueue) abandon(inFlight ports.Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.abandoned = true
+	for _, ev := range append(q.items, inFlight) {
+		switch ev.(type) {
+		case ports.Message, ports.Stream:
+			q.dropped++
+		}
+	}
+	q.items, q.messages = nil, 0
+	close(q.gone)
+	q.cond.Broadcast()
+}
+
+// close delivers the queued events and then closes the output channel.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *eventQueue) stats() ports.SessionStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return ports.SessionStats{Received: q.received, Dropped: q.dropped}
+}
+
+// pump hands queued events to the consumer one at a time.
+func (q *eventQueue) pump() {
+	defer close(q.out)
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		ev := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		if _, ok := ev.(ports.Message); ok {
+			q.messages--
+		}
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		select {
+		case q.out <- ev:
+			continue
+		case <-q.released:
+		}
+		timer := time.NewTimer(q.grace)
+		select {
+		case q.out <- ev:
+			timer.Stop()
+		case <-timer.C:
+			q.abandon(ev)
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+func msg(s string) ports.Message {
+	return ports.Message{Type: ports.TextMessage, Data: []byte(s)}
+}
+
+// drain closes q and returns the text of every message it delivers.
+func drain(q *eventQueue) []string {
+	q.close()
+	var got []string
+	for ev := range q.out {
+		if m, ok := ev.(ports.Message); ok {
+			got = append(got, string(m.Data))
+		}
+	}
+	return got
+}
+
+// fill pushes messages while nothing consumes the queue. The pump holds the
+// first message in flight, so a queue of size n buffers n+1 messages before
+// overflowing.
+func fill(t *testing.T, q *eventQueue, texts ...string) {
+	t.Helper()
+	for i, text := range texts {
+		if !q.push(msg(text)) {
+			t.Fatalf("push(%q) reported an overflow", text)
+		}
+		if i == 0 {
+			waitInFlight(t, q)
+		}
+	}
+}
+
+// waitInFlight waits until the pump has taken every queued event.
+func waitInFlight(t *testing.T, q *eventQueue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		n := len(q.items)
+		q.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pump did not take the queued event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	q := newEventQueue(2, ports.OverflowDropNewest)
+	fill(t, q, "1", "2", "3", "4", "5")
+
+	got := drain(q)
+	if len(got) != 3 || got[0] != "1" || got[2] != "3" {
+		t.Errorf("unexpected delivery: %v", got)
+	}
+	if s := q.stats(); s.Dropped != 2 || s.Received != 5 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q := newEventQueue(2, ports.OverflowDropOldest)
+	fill(t, q, "1", "2", "3", "4", "5")
+
+	got := drain(q)
+	if len(got) != 3 || got[0] != "1" || got[1] != "4" || got[2] != "5" {
+		t.Errorf("unexpected delivery: %v", got)
+	}
+	if s := q.stats(); s.Dropped != 2 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	q := newEventQueue(1, ports.OverflowDisconnect)
+	fill(t, q, "1", "2")
+	if q.push(msg("3")) {
+		t.Error("expected the overflowing push to fail")
+	}
+	if s := q.stats(); s.Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestQueueBlockKeepsEverything(t *testing.T) {
+	q := newEventQueue(1, ports.OverflowBlock)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, text := range []string{"1", "2", "3", "4"} {
+			q.push(msg(text))
+		}
+		q.push(ports.Closed{Code: ports.CloseNormalClosure})
+		q.close()
+	}()
+
+	var got []string
+	for ev := range q.out {
+		if m, ok := ev.(ports.Message); ok {
+			got = append(got, string(m.Data))
+		}
+	}
+	<-done
+	if len(got) != 4 {
+		t.Errorf("expected every message under the block policy, got %v", got)
+	}
+}
+
+func TestQueueNeverDropsLifecycleEvents(t *testing.T) {
+	q := newEventQueue(1, ports.OverflowDropNewest)
+	fill(t, q, "1", "2", "3")
+	q.push(ports.Closed{Code: ports.CloseNormalClosure})
+	q.close()
+
+	var last ports.Event
+	for ev := range q.out {
+		last = ev
+	}
+	if _, ok := last.(ports.Closed); !ok {
+		t.Errorf("expected Closed to be delivered last, got %#v", last)
+	}
+}
+
+func TestQueueReleaseGivesUpOnConsumer(t *testing.T) {
+	q := newEventQueue(1, ports.OverflowBlock)
+	fill(t, q, "1", "2")
+	q.release(10 * time.Millisecond)
+
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		q.push(msg("3")) // would wait for room forever without release
+		q.push(ports.Closed{Code: ports.CloseNormalClosure})
+		q.close()
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push still waits for a consumer that is gone")
+	}
+	select {
+	case <-q.gone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pump did not give up")
+	}
+	if _, ok := <-q.out; ok {
+		t.Error("expected the output channel to be closed")
+	}
+	if s := q.stats(); s.Dropped != 3 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []ports.OverflowPolicy{ports.OverflowBlock, ports.OverflowDropOldest, ports.OverflowDropNewest, ports.OverflowDisconnect} {
+		got, err := ports.ParseOverflowPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("round trip of %v failed: %v, %v", p, got, err)
+		}
+	}
+	if _, err := ports.ParseOverflowPolicy("shrug"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,300 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ravenpair/cli/internal/ports"
+)
+
+// writeWait bounds how long control frames may take to be written.
+const writeWait = 5 * time.Second
+
+// session implements ports.Session on top of a gorilla connection. A single
+// goroutine reads from the connection and publishes events; writes are
+// serialised by holding writeSem as gorilla allows only one concurrent
+// writer.
+type session struct {
+	conn  *websocket.Conn
+	queue *eventQueue
+	done  chan struct{}
+
+	writeSem chan struct{}
+	cfg      sessionConfig
+
+	mu            sync.Mutex
+	closeSent     bool
+	closeCode     int
+	closeReason   string
+	closeTimedOut bool
+}
+
+// sessionConfig holds the per-session settings derived from the Client and
+// ports.DialOptions.
+type sessionConfig struct {
+	pingInterval    time.Duration
+	closeTimeout    time.Duration
+	maxMessageSize  int64
+	streamThreshold int64
+	queueSize       int
+	overflow        ports.OverflowPolicy
+}
+
+func newSession(conn *websocket.Conn, opened ports.Opened, cfg sessionConfig) *session {
+	s := &session{
+		conn:     conn,
+		queue:    newEventQueue(cfg.queueSize, cfg.overflow),
+		done:     make(chan struct{}),
+		writeSem: make(chan struct{}, 1),
+		cfg:      cfg,
+	}
+	s.queue.push(opened)
+	conn.SetPongHandler(s.handlePong)
+
+	go s.readLoop()
+	if cfg.pingInterval > 0 {
+		go s.pingLoop(cfg.pingInterval)
+	}
+	return s
+}
+
+func (s *session) Events() <-chan ports.Event {
+	return s.queue.out
+}
+
+func (s *session) Stats() ports.SessionStats {
+	return s.queue.stats()
+}
+
+func (s *session) Send(ctx context.Context, msgType int, data []byte) error {
+	select {
+	case s.writeSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.writeSem }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := s.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	// Cancelling ctx interrupts the write. gorilla sets the deadline again
+	// for every frame, so it is moved until the write gives up. A message cut
+	// short leaves the connection unusable, so it is then dropped.
+	written := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		for {
+			_ = s.conn.UnderlyingConn().SetWriteDeadline(time.Now())
+			select {
+			case <-written:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	err := s.conn.WriteMessage(msgType, data)
+	close(written)
+	if !stop() && err != nil {
+		_ = s.conn.Close()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (s *session) Close(code int, reason string) error {
+	s.mu.Lock()
+	if s.closeSent {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closeSent, s.closeCode, s.closeReason = true, code, reason
+	s.mu.Unlock()
+	s.queue.release(s.cfg.closeTimeout)
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+
+	msg := websocket.FormatCloseMessage(code, reason)
+	err := s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		_ = s.conn.Close()
+		return err
+	}
+
+	// The read loop ends when the server echoes the close frame; give up on
+	// it after closeTimeout.
+	go func() {
+		timer := time.NewTimer(s.cfg.closeTimeout)
+		defer timer.Stop()
+		select {
+		case <-s.done:
+		case <-timer.C:
+			s.mu.Lock()
+			s.closeTimedOut = true
+			s.mu.Unlock()
+			_ = s.conn.Close()
+		}
+	}()
+	return nil
+}
+
+func (s *session) readLoop() {
+	defer s.queue.close()
+	defer close(s.done)
+	defer s.conn.Close()
+
+	for {
+		msgType, r, err := s.conn.NextReader()
+		if err == nil {
+			err = s.readMessage(msgType, r)
+		}
+		if err != nil {
+			s.queue.push(s.finalEvent(err))
+			return
+		}
+	}
+}
+
+// readMessage publishes the message read from r, buffering it unless it is
+// larger than the stream threshold.
+func (s *session) readMessage(msgType int, r io.Reader) error {
+	if s.cfg.streamThreshold <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return s.publish(ports.Message{Type: msgType, Data: data})
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r, s.cfg.streamThreshold+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(head)) <= s.cfg.streamThreshold {
+		return s.publish(ports.Message{Type: msgType, Data: head})
+	}
+
+	body := newStreamBody(io.MultiReader(bytes.NewReader(head), r))
+	s.queue.push(ports.Stream{Type: msgType, Body: body})
+	select {
+	case <-body.done:
+	case <-s.queue.gone:
+	}
+	// Skip whatever the consumer left unread so the next message can be read.
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// publish queues a received message, disconnecting when the queue overflows
+// under the OverflowDisconnect policy.
+func (s *session) publish(msg ports.Message) error {
+	if s.queue.push(msg) {
+		return nil
+	}
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "client cannot keep up")
+	_ = s.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+	return errQueueOverflow
+}
+
+// errQueueOverflow ends the read loop when the queue overflows.
+var errQueueOverflow = errors.New("queue overflow")
+
+// finalEvent turns the error that ended the read loop into the session's last
+// event.
+func (s *session) finalEvent(err error) ports.Event {
+	s.mu.Lock()
+	local, code, reason, timedOut := s.closeSent, s.closeCode, s.closeReason, s.closeTimedOut
+	s.mu.Unlock()
+
+	if errors.Is(err, errQueueOverflow) {
+		return ports.Error{Err: fmt.Errorf("%w: more than %d messages waiting", ports.ErrQueueOverflow, s.cfg.queueSize)}
+	}
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return ports.Error{Err: fmt.Errorf("%w of %d bytes", ports.ErrMessageTooBig, s.cfg.maxMessageSize)}
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		// gorilla reports a connection that dropped without a closing
+		// handshake as 1006, a code never sent on the wire.
+		if closeErr.Code == websocket.CloseAbnormalClosure && !local {
+			return ports.Error{Err: err}
+		}
+		return ports.Closed{Code: closeErr.Code, Reason: closeErr.Text, Local: local}
+	}
+	if timedOut {
+		return ports.Closed{Code: ports.CloseAbnormalClosure, Reason: "closing handshake timed out", Local: true}
+	}
+	if local {
+		return ports.Closed{Code: code, Reason: reason, Local: true}
+	}
+	return ports.Error{Err: err}
+}
+
+func (s *session) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err := s.conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handlePong runs on the read goroutine for every pong received.
+func (s *session) handlePong(appData string) error {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return nil
+	}
+	s.queue.push(ports.Pong{RTT: time.Since(time.Unix(0, sent))})
+	return nil
+}
+
+// streamBody is the Body of a ports.Stream event. done is closed once the
+// consumer has read it to the end or closed it, handing the connection back
+// to the read loop.
+type streamBody struct {
+	r    io.Reader
+	once sync.Once
+	done chan struct{}
+}
+
+func newStreamBody(r io.Reader) *streamBody {
+	return &streamBody{r: r, done: make(chan struct{})}
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	b.finish()
+	return nil
+}
+
+func (b *streamBody) finish() {
+	b.once.Do(func() { close(b.done) })
+}
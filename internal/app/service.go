@@ -2,9 +2,11 @@ package app
 
 import (
 	"context"
+	"net/url"
 	"strings"
 
 	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/transport"
 )
 
 // Service is the application layer that orchestrates the outgoing ports.
@@ -19,18 +21,37 @@ func New(api ports.APIClient, ws ports.WSClient) *Service {
 }
 
 // Connect builds the WebSocket URL from serverURL + path, attaches the Bearer
-// token header when provided, and delegates to the WSClient port.
-func (s *Service) Connect(ctx context.Context, serverURL, path, token string, onMessage ports.MessageHandler) error {
-	wsURL := toWebSocketURL(serverURL) + path
+// token header when provided, and opens a session through the WSClient port. A path
+// prefix in serverURL is kept, so path is resolved below it.
+func (s *Service) Connect(ctx context.Context, serverURL, path, token string, opts ports.DialOptions) (ports.Session, error) {
+	wsURL, err := transport.JoinPath(toWebSocketURL(serverURL), path)
+	if err != nil {
+		return nil, err
+	}
 	headers := map[string]string{}
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
 	if token != "" {
 		headers["Authorization"] = "Bearer " + token
 	}
-	return s.WS.Dial(ctx, wsURL, headers, onMessage)
+	opts.Headers = headers
+	return s.WS.Open(ctx, wsURL, opts)
 }
 
-// toWebSocketURL converts an http(s):// URL to ws(s)://.
+// PairPath returns the WebSocket path of the pair session pairID below the
+// endpoint path, as in /ws/pairs/<id>.
+func PairPath(path, pairID string) string {
+	return strings.TrimRight(path, "/") + "/pairs/" + url.PathEscape(pairID)
+}
+
+// toWebSocketURL converts an http(s):// URL to ws(s)://. A unix:// address
+// becomes a plain ws:// URL on a placeholder host; the WSClient is expected to
+// dial the socket itself.
 func toWebSocketURL(u string) string {
+	if _, ok := transport.SocketPath(u); ok {
+		return "ws://" + transport.UnixSocketHost
+	}
 	u = strings.TrimRight(u, "/")
 	switch {
 	case strings.HasPrefix(u, "https://"):
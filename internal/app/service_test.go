@@ -16,6 +16,7 @@ func TestToWebSocketURL(t *testing.T) {
 		{"https://example.com", "wss://example.com"},
 		{"http://example.com/", "ws://example.com"},
 		{"ws://already-ws.com", "ws://already-ws.com"},
+		{"unix:///run/ravenpair.sock", "ws://localhost"},
 	}
 	for _, tc := range cases {
 		got := toWebSocketURL(tc.input)
@@ -28,38 +29,67 @@ func TestToWebSocketURL(t *testing.T) {
 func TestServiceConnectBuildsURL(t *testing.T) {
 	var capturedURL string
 	mock := &mockWSClient{
-		dialFn: func(_ context.Context, wsURL string, _ map[string]string, _ ports.MessageHandler) error {
+		openFn: func(_ context.Context, wsURL string, _ ports.DialOptions) (ports.Session, error) {
 			capturedURL = wsURL
-			return nil
+			return nil, nil
 		},
 	}
 	svc := New(nil, mock)
-	_ = svc.Connect(context.Background(), "http://localhost:8080", "/ws", "", func(int, []byte) {})
+	_, _ = svc.Connect(context.Background(), "http://localhost:8080", "/ws", "", ports.DialOptions{})
 	if capturedURL != "ws://localhost:8080/ws" {
 		t.Errorf("unexpected wsURL: %s", capturedURL)
 	}
 }
 
+func TestServiceConnectKeepsBasePath(t *testing.T) {
+	var capturedURL string
+	mock := &mockWSClient{
+		openFn: func(_ context.Context, wsURL string, _ ports.DialOptions) (ports.Session, error) {
+			capturedURL = wsURL
+			return nil, nil
+		},
+	}
+	svc := New(nil, mock)
+	_, _ = svc.Connect(context.Background(), "https://tools.corp/ravenpair/", "/ws", "", ports.DialOptions{})
+	if capturedURL != "wss://tools.corp/ravenpair/ws" {
+		t.Errorf("unexpected wsURL: %s", capturedURL)
+	}
+}
+
 func TestServiceConnectSetsToken(t *testing.T) {
 	var capturedHeaders map[string]string
 	mock := &mockWSClient{
-		dialFn: func(_ context.Context, _ string, headers map[string]string, _ ports.MessageHandler) error {
-			capturedHeaders = headers
-			return nil
+		openFn: func(_ context.Context, _ string, opts ports.DialOptions) (ports.Session, error) {
+			capturedHeaders = opts.Headers
+			return nil, nil
 		},
 	}
 	svc := New(nil, mock)
-	_ = svc.Connect(context.Background(), "http://localhost:8080", "/ws", "tok123", func(int, []byte) {})
+	_, _ = svc.Connect(context.Background(), "http://localhost:8080", "/ws", "tok123", ports.DialOptions{})
 	if capturedHeaders["Authorization"] != "Bearer tok123" {
 		t.Errorf("expected Bearer tok123, got %s", capturedHeaders["Authorization"])
 	}
 }
 
+func TestPairPath(t *testing.T) {
+	cases := map[[2]string]string{
+		{"/ws", "p1"}:        "/ws/pairs/p1",
+		{"/ws/", "p1"}:       "/ws/pairs/p1",
+		{"/ws", "a b/c"}:     "/ws/pairs/a%20b%2Fc",
+		{"/api/ws", "alpha"}: "/api/ws/pairs/alpha",
+	}
+	for in, want := range cases {
+		if got := PairPath(in[0], in[1]); got != want {
+			t.Errorf("PairPath(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
 // mockWSClient is a test double for ports.WSClient.
 type mockWSClient struct {
-	dialFn func(ctx context.Context, wsURL string, headers map[string]string, onMessage ports.MessageHandler) error
+	openFn func(ctx context.Context, wsURL string, opts ports.DialOptions) (ports.Session, error)
 }
 
-func (m *mockWSClient) Dial(ctx context.Context, wsURL string, headers map[string]string, onMessage ports.MessageHandler) error {
-	return m.dialFn(ctx, wsURL, headers, onMessage)
+func (m *mockWSClient) Open(ctx context.Context, wsURL string, opts ports.DialOptions) (ports.Session, error) {
+	return m.openFn(ctx, wsURL, opts)
 }
@@ -0,0 +1,250 @@
+// Package asciicast reads and writes terminal recordings in the asciicast v2
+// format of asciinema: a JSON header line followed by one JSON array per
+// event, [time, code, data], with time in seconds from the start.
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Version is the asciicast format version this package implements.
+const Version = 2
+
+// Event codes.
+const (
+	// Output is data printed by the terminal.
+	Output = "o"
+	// Input is data typed into the terminal.
+	Input = "i"
+	// Resize changes the terminal size; its data is "COLSxROWS".
+	Resize = "r"
+	// Marker marks a point of interest; its data is a label.
+	Marker = "m"
+)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is an entry of a recording.
+type Event struct {
+	// Time is the time since the start of the recording.
+	Time time.Duration
+	Code string
+	Data string
+}
+
+// Size returns the size a Resize event sets.
+func (e Event) Size() (cols, rows int, err error) {
+	if e.Code != Resize {
+		return 0, 0, fmt.Errorf("not a resize event: %q", e.Code)
+	}
+	if _, err := fmt.Sscanf(e.Data, "%dx%d", &cols, &rows); err != nil || cols <= 0 || rows <= 0 {
+		return 0, 0, fmt.Errorf("invalid terminal size %q", e.Data)
+	}
+	return cols, rows, nil
+}
+
+// Writer records events. Its methods may be called concurrently.
+type Writer struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	c     io.Closer
+	start time.Time
+	now   func() time.Time
+	// partial holds the end of the last output when it split a UTF-8
+	// sequence; it is written with the next output.
+	partial []byte
+	err     error
+}
+
+// NewWriter writes the header of a recording starting now to w. A zero
+// header Version and Timestamp are filled in.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	return newWriter(w, h, time.Now)
+}
+
+func newWriter(w io.Writer, h Header, now func() time.Time) (*Writer, error) {
+	start := now()
+	if h.Version == 0 {
+		h.Version = Version
+	}
+	if h.Timestamp == 0 {
+		h.Timestamp = start.Unix()
+	}
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	rw := &Writer{w: bufio.NewWriter(w), start: start, now: now}
+	if c, ok := w.(io.Closer); ok {
+		rw.c = c
+	}
+	rw.w.Write(append(line, '\n'))
+	if err := rw.w.Flush(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Create creates a recording file at path, readable by its owner only since
+// it may hold private session content.
+func Create(path string, h Header) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Output records terminal output. Data need not end on a character
+// boundary.
+func (w *Writer) Output(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data = append(w.partial, data...)
+	w.partial = nil
+	if end := completeUTF8(data); end < len(data) {
+		w.partial = append([]byte(nil), data[end:]...)
+		data = data[:end]
+	}
+	if len(data) == 0 {
+		return w.err
+	}
+	return w.write(Output, string(data))
+}
+
+// Resize records a change of terminal size.
+func (w *Writer) Resize(cols, rows int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(Resize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close flushes the recording and closes the underlying writer, if it is an
+// io.Closer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.write(Output, string(w.partial))
+		w.partial = nil
+	}
+	err := w.err
+	if ferr := w.w.Flush(); err == nil {
+		err = ferr
+	}
+	if w.c != nil {
+		if cerr := w.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (w *Writer) write(code, data string) error {
+	if w.err != nil {
+		return w.err
+	}
+	t := w.now().Sub(w.start).Seconds()
+	line, err := json.Marshal([]interface{}{json.Number(fmt.Sprintf("%.6f", t)), code, data})
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(append(line, '\n')); err != nil {
+		w.err = err
+		return err
+	}
+	// Flush each event, so that the recording survives a crash.
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// completeUTF8 returns the length of data without an incomplete UTF-8
+// sequence at its end.
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if !utf8.FullRune(data[i:]) {
+			return i
+		}
+		break
+	}
+	return len(data)
+}
+
+// ErrFormat is returned for input that is not an asciicast v2 recording.
+var ErrFormat = errors.New("not an asciicast v2 recording")
+
+// Read reads a whole recording.
+func Read(r io.Reader) (Header, []Event, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var h Header
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return Header{}, nil, err
+		}
+		return Header{}, nil, fmt.Errorf("%w: empty file", ErrFormat)
+	}
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: header: %v", ErrFormat, err)
+	}
+	if h.Version != Version {
+		return Header{}, nil, fmt.Errorf("%w: version %d", ErrFormat, h.Version)
+	}
+
+	var events []Event
+	for line := 2; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var fields []json.RawMessage
+		var e Event
+		var secs float64
+		err := json.Unmarshal(sc.Bytes(), &fields)
+		if err == nil && len(fields) != 3 {
+			err = fmt.Errorf("%d fields, want 3", len(fields))
+		}
+		if err == nil {
+			err = json.Unmarshal(fields[0], &secs)
+		}
+		if err == nil {
+			err = json.Unmarshal(fields[1], &e.Code)
+		}
+		if err == nil {
+			err = json.Unmarshal(fields[2], &e.Data)
+		}
+		if err != nil {
+			return Header{}, nil, fmt.Errorf("%w: line %d: %v", ErrFormat, line, err)
+		}
+		e.Time = time.Duration(secs * float64(time.Second))
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return Header{}, nil, err
+	}
+	return h, events, nil
+}
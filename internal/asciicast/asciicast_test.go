@@ -0,0 +1,63 @@
+package asciicast
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	clock := start
+	var buf bytes.Buffer
+	w, err := newWriter(&buf, Header{Width: 80, Height: 24, Command: "bash"}, func() time.Time { return clock })
+	if err != nil {
+		t.Fatalf("newWriter: %v", err)
+	}
+	clock = start.Add(1500 * time.Millisecond)
+	w.Output([]byte("caf\xc3"))
+	clock = start.Add(2 * time.Second)
+	w.Output([]byte("\xa9\r\n"))
+	w.Resize(100, 30)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := `{"version":2,"width":80,"height":24,"timestamp":1767366245,"command":"bash"}
+[1.500000,"o","caf"]
+[2.000000,"o","é\r\n"]
+[2.000000,"r","100x30"]
+`
+	if buf.String() != want {
+		t.Errorf("recording:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	h, events, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if h.Width != 80 || h.Height != 24 || len(events) != 3 {
+		t.Fatalf("unexpected recording %+v %+v", h, events)
+	}
+	if events[1].Time != 2*time.Second || events[1].Data != "é\r\n" {
+		t.Errorf("unexpected event %+v", events[1])
+	}
+	if cols, rows, err := events[2].Size(); err != nil || cols != 100 || rows != 30 {
+		t.Errorf("Size = %d, %d, %v", cols, rows, err)
+	}
+}
+
+func TestReadRejectsOtherFormats(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`{"version":1,"width":80,"height":24}`,
+		"{\"version\":2,\"width\":80,\"height\":24}\n[1.0,\"o\"]",
+		"{\"version\":2,\"width\":80,\"height\":24}\n[\"soon\",\"o\",\"x\"]",
+	} {
+		if _, _, err := Read(strings.NewReader(in)); !errors.Is(err, ErrFormat) {
+			t.Errorf("Read(%q): expected ErrFormat, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack is the codec of MessagePack binary messages.
+var MessagePack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("msgpack: %w", err)
+	}
+	return encodeJSON(v)
+}
+
+func (msgpackCodec) Encode(doc []byte) ([]byte, error) {
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(v)
+}
+
+// CBOR is the codec of CBOR binary messages.
+var CBOR Codec = cborCodec{}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return "cbor" }
+func (cborCodec) Binary() bool { return true }
+
+func (cborCodec) Decode(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("cbor: %w", err)
+	}
+	return encodeJSON(v)
+}
+
+func (cborCodec) Encode(doc []byte) ([]byte, error) {
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(v)
+}
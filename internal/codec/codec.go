@@ -0,0 +1,170 @@
+// Package codec converts WebSocket messages between their wire encoding and
+// JSON, the structured form the rest of the CLI filters and renders.
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Codec is a message encoding.
+type Codec interface {
+	// Name identifies the codec, as given to --codec.
+	Name() string
+	// Binary reports whether encoded messages travel in binary frames rather
+	// than text frames.
+	Binary() bool
+	// Decode converts an encoded message to a JSON document.
+	Decode(data []byte) ([]byte, error)
+	// Encode converts a JSON document to the codec's encoding.
+	Encode(doc []byte) ([]byte, error)
+}
+
+// JSON is the codec of plain JSON text messages. It passes messages through
+// unchanged after checking that they are JSON.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Decode(data []byte) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, errors.New("json: not a JSON document")
+	}
+	return data, nil
+}
+
+func (c jsonCodec) Encode(doc []byte) ([]byte, error) {
+	return c.Decode(doc)
+}
+
+// Registry looks codecs up by name or by negotiated subprotocol.
+type Registry struct {
+	codecs map[string]Codec
+}
+
+// NewRegistry returns a registry holding the JSON, MessagePack and CBOR
+// codecs.
+func NewRegistry() *Registry {
+	r := &Registry{codecs: make(map[string]Codec)}
+	r.Register(JSON)
+	r.Register(MessagePack)
+	r.Register(CBOR)
+	return r
+}
+
+// Register adds c to the registry, replacing any codec of the same name.
+func (r *Registry) Register(c Codec) {
+	r.codecs[c.Name()] = c
+}
+
+// Lookup returns the codec called name.
+func (r *Registry) Lookup(name string) (Codec, error) {
+	if c, ok := r.codecs[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q: use one of %s", name, strings.Join(r.Names(), ", "))
+}
+
+// Names lists the registered codecs in alphabetical order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.codecs))
+	for name := range r.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForSubprotocol picks the codec for a negotiated subprotocol. The codec is
+// named by the subprotocol itself, as in "msgpack", or by its suffix after
+// the last "+", as in "ravenpair.v2+cbor". It returns false when neither names
+// a registered codec.
+func (r *Registry) ForSubprotocol(subprotocol string) (Codec, bool) {
+	if c, ok := r.codecs[subprotocol]; ok {
+		return c, true
+	}
+	if i := strings.LastIndex(subprotocol, "+"); i >= 0 {
+		c, ok := r.codecs[subprotocol[i+1:]]
+		return c, ok
+	}
+	return nil, false
+}
+
+// decodeJSON parses a JSON document for encoding in another format. Integral
+// numbers become int64 so that they are not encoded as floats.
+func decodeJSON(doc []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("not a JSON document: %w", err)
+	}
+	return fromJSONNumbers(v), nil
+}
+
+func fromJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = fromJSONNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
+
+// encodeJSON renders a value decoded from another format as JSON. Maps with
+// non-string keys get their keys formatted as strings, byte strings become
+// base64 and non-finite floats become strings, since JSON has none of these.
+func encodeJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(toJSONValue(v))
+}
+
+func toJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = toJSONValue(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = toJSONValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = toJSONValue(e)
+		}
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float32:
+		return toJSONValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprint(v)
+		}
+		return v
+	}
+	return v
+}
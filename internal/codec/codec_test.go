@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// sameJSON reports whether a and b hold the same JSON value.
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestBinaryCodecsRoundTrip(t *testing.T) {
+	doc := []byte(`{"type":"cursor","seq":42,"payload":{"path":"a.go","line":3,"ratio":0.5,"tags":["x",null,true]}}`)
+	for _, c := range []Codec{MessagePack, CBOR} {
+		encoded, err := c.Encode(doc)
+		if err != nil {
+			t.Fatalf("%s Encode: %v", c.Name(), err)
+		}
+		if json.Valid(encoded) {
+			t.Errorf("%s: expected a binary encoding", c.Name())
+		}
+		decoded, err := c.Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s Decode: %v", c.Name(), err)
+		}
+		if !sameJSON(t, doc, decoded) {
+			t.Errorf("%s round trip: got %s", c.Name(), decoded)
+		}
+	}
+}
+
+func TestCBORNonStringKeys(t *testing.T) {
+	// {1: h'0102'}
+	decoded, err := CBOR.Decode([]byte{0xa1, 0x01, 0x42, 0x01, 0x02})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if string(decoded) != `{"1":"AQI="}` {
+		t.Errorf("unexpected JSON: %s", decoded)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if c, err := r.Lookup("cbor"); err != nil || c != CBOR {
+		t.Errorf("Lookup(cbor) = %v, %v", c, err)
+	}
+	if _, err := r.Lookup("yaml"); err == nil {
+		t.Error("expected an unknown codec to fail")
+	}
+
+	cases := map[string]Codec{"msgpack": MessagePack, "ravenpair.v2+cbor": CBOR, "ravenpair.v2+json": JSON}
+	for sp, want := range cases {
+		if c, ok := r.ForSubprotocol(sp); !ok || c != want {
+			t.Errorf("ForSubprotocol(%q) = %v, %v", sp, c, ok)
+		}
+	}
+	for _, sp := range []string{"", "ravenpair.v2", "ravenpair.v2+yaml"} {
+		if _, ok := r.ForSubprotocol(sp); ok {
+			t.Errorf("expected no codec for %q", sp)
+		}
+	}
+}
+
+// writeDescriptorSet writes a descriptor set declaring
+// message test.Event { string type = 1; uint32 seq = 2; string pair_id = 3; }.
+func writeDescriptorSet(t *testing.T) string {
+	t.Helper()
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("event.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("type", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("seq", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT32),
+				field("pair_id", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			},
+		}},
+	}}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "event.pb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProtobuf(t *testing.T) {
+	path := writeDescriptorSet(t)
+	c, err := NewProtobuf(path, "test.Event")
+	if err != nil {
+		t.Fatalf("NewProtobuf: %v", err)
+	}
+
+	doc := []byte(`{"type":"join","seq":9,"pair_id":"p1"}`)
+	encoded, err := c.Encode(doc)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !sameJSON(t, doc, decoded) {
+		t.Errorf("round trip: got %s", decoded)
+	}
+
+	if _, err := NewProtobuf(path, "test.Missing"); err == nil {
+		t.Error("expected an unknown message to fail")
+	}
+	if _, err := c.Decode([]byte{0xff}); err == nil {
+		t.Error("expected invalid Protobuf to fail")
+	}
+}
@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufCodec encodes every message as one Protobuf message type, described
+// at run time by a descriptor set.
+type protobufCodec struct {
+	desc protoreflect.MessageDescriptor
+}
+
+// NewProtobuf returns a codec for the Protobuf message called messageName,
+// such as "ravenpair.v1.Envelope", read from the descriptor set file at path,
+// as written by protoc --descriptor_set_out --include_imports. Field names
+// appear in JSON as written in the .proto file.
+func NewProtobuf(path, messageName string) (Codec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("loading descriptor set %s: %w", path, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %q in %s: %w", messageName, path, err)
+	}
+	desc, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q in %s is not a message", messageName, path)
+	}
+	return protobufCodec{desc: desc}, nil
+}
+
+func (protobufCodec) Name() string { return "protobuf" }
+func (protobufCodec) Binary() bool { return true }
+
+func (c protobufCodec) Decode(data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("protobuf: %w", err)
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+}
+
+func (c protobufCodec) Encode(doc []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.desc)
+	if err := protojson.Unmarshal(doc, msg); err != nil {
+		return nil, fmt.Errorf("protobuf: %w", err)
+	}
+	return proto.Marshal(msg)
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ProjectFileNames lists the per-repository config file names, in the order
+// they are looked for in each directory.
+var ProjectFileNames = []string{".ravenpair.yaml", "ravenpair.toml"}
+
+// secretNames are setting names whose values Redacted hides. A key matches
+// when its last dotted segment is listed.
+var secretNames = []string{"token", "secret", "password"}
+
+// ProjectKeys lists the settings a project file may set. Anything else, such
+// as the server, TLS, proxy, header or authentication settings, or a setting
+// naming who receives data or what runs, could let a cloned repository
+// redirect the user's token or traffic, so it may only come from the home
+// config, the environment or flags.
+var ProjectKeys = []string{
+	"connect.path",
+	"forward.pair",
+	"git.pair",
+	"pair.name",
+	"sync.pair",
+	"transfer.rate_limit",
+}
+
+// FindProjectFile searches dir and its parents for a project config file and
+// returns the first one found. The search stops before stopDir (typically the
+// user's home directory, whose .ravenpair.yaml is the home config) and at the
+// filesystem root. It returns "" when no project file exists.
+func FindProjectFile(dir, stopDir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if stopDir != "" {
+		if stopDir, err = filepath.Abs(stopDir); err != nil {
+			return "", err
+		}
+	}
+
+	for {
+		if dir == stopDir {
+			return "", nil
+		}
+		for _, name := range ProjectFileNames {
+			p := filepath.Join(dir, name)
+			info, err := os.Stat(p)
+			if err == nil && !info.IsDir() {
+				return p, nil
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// IsSecret reports whether key names a secret setting.
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	for _, name := range secretNames {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isProjectKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range ProjectKeys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordURL returns value parsed as a URL when it embeds a password, such as
+// a proxy URL with credentials, and nil otherwise.
+func passwordURL(value interface{}) *url.URL {
+	s, ok := value.(string)
+	if !ok || !strings.Contains(s, "://") {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return nil
+	}
+	if _, ok := u.User.Password(); !ok {
+		return nil
+	}
+	return u
+}
+
+// Redacted formats the value of key for display, hiding secrets.
+func Redacted(key string, value interface{}) string {
+	if u := passwordURL(value); u != nil {
+		return u.Redacted()
+	}
+	s := fmt.Sprint(value)
+	if IsSecret(key) && s != "" {
+		return "<redacted>"
+	}
+	return s
+}
+
+// LoadProjectFile reads the project config at path and returns its settings.
+// Files that set anything but ProjectKeys are rejected, so that tokens never
+// end up committed alongside the code and a cloned repository cannot change
+// where or how the client connects.
+func LoadProjectFile(path string) (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading project config %s: %w", path, err)
+	}
+
+	var refused []string
+	for _, key := range v.AllKeys() {
+		if !isProjectKey(key) || passwordURL(v.Get(key)) != nil {
+			refused = append(refused, key)
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		return nil, fmt.Errorf("project config %s must not set %s: a project file may only set %s; set the others in the home config, the environment or on the command line",
+			path, strings.Join(refused, ", "), strings.Join(ProjectKeys, ", "))
+	}
+
+	return v.AllSettings(), nil
+}
+
+// Keys flattens settings into the dotted, lower-case key form used by viper.
+func Keys(settings map[string]interface{}) []string {
+	var keys []string
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			key := strings.ToLower(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if sub, ok := v.(map[string]interface{}); ok {
+				walk(key, sub)
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	walk("", settings)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,265 @@
+package dirsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// DefaultBlockSize is the size of the blocks a signature is made of.
+const DefaultBlockSize = 2048
+
+// strongSize is how much of a block's SHA-256 digest a signature keeps.
+const strongSize = 16
+
+// sigEntrySize is the size of a block in an encoded signature: its weak
+// and strong checksums.
+const sigEntrySize = 4 + strongSize
+
+// ErrDelta reports a delta that does not apply to the file it was made for.
+var ErrDelta = errors.New("invalid delta")
+
+// Signature describes a file as the checksums of its blocks, so that a
+// partner can send only what differs from it, as rsync does. Every block is
+// BlockSize long but the last, which may be shorter.
+type Signature struct {
+	BlockSize int
+	Size      int64
+	weak      []uint32
+	strong    [][strongSize]byte
+}
+
+// weakSum is rsync's rolling checksum of a block: a is the sum of its
+// bytes and b the sum of the prefix sums, both modulo 2^16.
+type weakSum struct {
+	a, b uint32
+	n    int
+}
+
+func newWeakSum(block []byte) weakSum {
+	s := weakSum{n: len(block)}
+	for i, c := range block {
+		s.a += uint32(c)
+		s.b += uint32(len(block)-i) * uint32(c)
+	}
+	return s
+}
+
+func (s weakSum) sum() uint32 {
+	return s.a&0xffff | s.b<<16
+}
+
+// roll moves the block one byte forward, dropping out and adding in.
+func (s *weakSum) roll(out, in byte) {
+	s.a += uint32(in) - uint32(out)
+	s.b += s.a - uint32(s.n)*uint32(out)
+}
+
+func strongSum(block []byte) [strongSize]byte {
+	h := sha256.Sum256(block)
+	var s [strongSize]byte
+	copy(s[:], h[:])
+	return s
+}
+
+// NewSignature computes the signature of data.
+func NewSignature(data []byte, blockSize int) *Signature {
+	sig := &Signature{BlockSize: blockSize, Size: int64(len(data))}
+	for off := 0; off < len(data); off += blockSize {
+		block := data[off:min(off+blockSize, len(data))]
+		sig.weak = append(sig.weak, newWeakSum(block).sum())
+		sig.strong = append(sig.strong, strongSum(block))
+	}
+	return sig
+}
+
+// MarshalBinary encodes the signature.
+func (sig *Signature) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 12, 12+len(sig.weak)*sigEntrySize)
+	binary.BigEndian.PutUint32(b, uint32(sig.BlockSize))
+	binary.BigEndian.PutUint64(b[4:], uint64(sig.Size))
+	for i, w := range sig.weak {
+		b = binary.BigEndian.AppendUint32(b, w)
+		b = append(b, sig.strong[i][:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a signature encoded by MarshalBinary.
+func (sig *Signature) UnmarshalBinary(b []byte) error {
+	if len(b) < 12 || (len(b)-12)%sigEntrySize != 0 {
+		return errors.New("invalid signature")
+	}
+	blockSize := int(binary.BigEndian.Uint32(b))
+	size := int64(binary.BigEndian.Uint64(b[4:]))
+	blocks := (len(b) - 12) / sigEntrySize
+	if blockSize <= 0 || size < 0 || int64(blocks) != (size+int64(blockSize)-1)/int64(blockSize) {
+		return errors.New("invalid signature")
+	}
+	*sig = Signature{BlockSize: blockSize, Size: size}
+	for off := 12; off < len(b); off += sigEntrySize {
+		sig.weak = append(sig.weak, binary.BigEndian.Uint32(b[off:]))
+		var s [strongSize]byte
+		copy(s[:], b[off+4:])
+		sig.strong = append(sig.strong, s)
+	}
+	return nil
+}
+
+// blockLen is the length of block i.
+func (sig *Signature) blockLen(i int) int {
+	return int(min(int64(sig.BlockSize), sig.Size-int64(i)*int64(sig.BlockSize)))
+}
+
+// Delta operations: copy blocks of the partner's file, or insert data.
+const (
+	opCopy   = 'C'
+	opInsert = 'I'
+)
+
+type deltaWriter struct {
+	buf     []byte
+	literal []byte
+	// first and count describe the run of blocks waiting to be copied.
+	first, count int
+}
+
+func (w *deltaWriter) copyBlock(i int) {
+	w.flushLiteral()
+	if w.count > 0 && w.first+w.count == i {
+		w.count++
+		return
+	}
+	w.flushCopy()
+	w.first, w.count = i, 1
+}
+
+func (w *deltaWriter) insert(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.flushCopy()
+	w.literal = append(w.literal, data...)
+}
+
+func (w *deltaWriter) flushCopy() {
+	if w.count == 0 {
+		return
+	}
+	w.buf = append(w.buf, opCopy)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(w.first))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(w.count))
+	w.count = 0
+}
+
+func (w *deltaWriter) flushLiteral() {
+	if len(w.literal) == 0 {
+		return
+	}
+	w.buf = append(w.buf, opInsert)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(w.literal)))
+	w.buf = append(w.buf, w.literal...)
+	w.literal = w.literal[:0]
+}
+
+// Delta encodes data as the blocks it shares with the file sig describes
+// and the bytes in between.
+func Delta(sig *Signature, data []byte) []byte {
+	w := &deltaWriter{}
+	byWeak := make(map[uint32][]int, len(sig.weak))
+	for i, weak := range sig.weak {
+		if sig.blockLen(i) == sig.BlockSize {
+			byWeak[weak] = append(byWeak[weak], i)
+		}
+	}
+	match := func(window []byte, candidates []int) int {
+		strong := strongSum(window)
+		for _, i := range candidates {
+			if sig.strong[i] == strong {
+				return i
+			}
+		}
+		return -1
+	}
+
+	bs := sig.BlockSize
+	pos, start := 0, 0 // start is where the pending literal begins
+	var sum weakSum
+	if len(data) >= bs {
+		sum = newWeakSum(data[:bs])
+	}
+	for len(byWeak) > 0 && pos+bs <= len(data) {
+		if i := match(data[pos:pos+bs], byWeak[sum.sum()]); i >= 0 {
+			w.insert(data[start:pos])
+			w.copyBlock(i)
+			pos += bs
+			start = pos
+			if pos+bs <= len(data) {
+				sum = newWeakSum(data[pos : pos+bs])
+			}
+			continue
+		}
+		if pos+bs < len(data) {
+			sum.roll(data[pos], data[pos+bs])
+		}
+		pos++
+	}
+	// The last block of the partner's file may be shorter than the others;
+	// it can only match the end of data.
+	if last := len(sig.weak) - 1; last >= 0 && sig.blockLen(last) < bs {
+		n := sig.blockLen(last)
+		if tail := len(data) - n; tail >= start {
+			if window := data[tail:]; newWeakSum(window).sum() == sig.weak[last] && match(window, []int{last}) == last {
+				w.insert(data[start:tail])
+				w.copyBlock(last)
+				start = len(data)
+			}
+		}
+	}
+	w.insert(data[start:])
+	w.flushLiteral()
+	w.flushCopy()
+	return w.buf
+}
+
+// Patch applies a delta to base, the file whose signature, of blocks of
+// blockSize bytes, the delta was made against. It returns ErrDelta for a
+// delta copying blocks base does not have or making a file larger than
+// MaxFileSize.
+func Patch(base []byte, blockSize int, delta []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for len(delta) > 0 {
+		switch delta[0] {
+		case opCopy:
+			if len(delta) < 9 {
+				return nil, ErrDelta
+			}
+			first, count := int64(binary.BigEndian.Uint32(delta[1:])), int64(binary.BigEndian.Uint32(delta[5:]))
+			from, to := first*int64(blockSize), (first+count)*int64(blockSize)
+			// Only the last block of base may be short.
+			if count == 0 || to-int64(blockSize) >= int64(len(base)) {
+				return nil, ErrDelta
+			}
+			to = min(to, int64(len(base)))
+			if int64(out.Len())+to-from > MaxFileSize {
+				return nil, ErrDelta
+			}
+			out.Write(base[from:to])
+			delta = delta[9:]
+		case opInsert:
+			if len(delta) < 5 {
+				return nil, ErrDelta
+			}
+			n := int64(binary.BigEndian.Uint32(delta[1:]))
+			if int64(len(delta)-5) < n || int64(out.Len())+n > MaxFileSize {
+				return nil, ErrDelta
+			}
+			out.Write(delta[5 : 5+n])
+			delta = delta[5+n:]
+		default:
+			return nil, ErrDelta
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,299 @@
+package dirsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+func TestDelta(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := make([]byte, 10*DefaultBlockSize+123)
+	rng.Read(base)
+	edited := append([]byte("inserted at the start"), base[:3000]...)
+	edited = append(edited, []byte("changed in the middle")...)
+	edited = append(edited, base[3100:]...)
+
+	cases := map[string][2][]byte{
+		"edited":    {base, edited},
+		"unchanged": {base, base},
+		"new file":  {nil, []byte("hello")},
+		"emptied":   {base, nil},
+		"short":     {[]byte("abc"), []byte("xabc")},
+	}
+	for name, c := range cases {
+		sig := NewSignature(c[0], DefaultBlockSize)
+		b, _ := sig.MarshalBinary()
+		var decoded Signature
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: UnmarshalBinary: %v", name, err)
+		}
+		delta := Delta(&decoded, c[1])
+		got, err := Patch(c[0], DefaultBlockSize, delta)
+		if err != nil || !bytes.Equal(got, c[1]) {
+			t.Errorf("%s: Patch = %d bytes, %v; want %d bytes", name, len(got), err, len(c[1]))
+		}
+		if name == "edited" && len(delta) > 3*DefaultBlockSize {
+			t.Errorf("delta of a small edit is %d bytes", len(delta))
+		}
+		if name == "unchanged" && len(delta) > 20 {
+			t.Errorf("delta of an unchanged file is %d bytes", len(delta))
+		}
+	}
+	if _, err := Patch([]byte("abc"), DefaultBlockSize, []byte{opCopy, 0, 0, 0, 5, 0, 0, 0, 1}); err == nil {
+		t.Error("expected a copy past the end of the base to fail")
+	}
+	if _, err := Patch([]byte("abc"), DefaultBlockSize, []byte{opCopy, 0, 0, 0, 0, 0, 0, 0, 2}); err == nil {
+		t.Error("expected a copy running past the end of the base to fail")
+	}
+
+	// A delta copying the same blocks again and again must not make a file
+	// larger than MaxFileSize.
+	base = make([]byte, DefaultBlockSize)
+	var bomb []byte
+	for i := 0; i <= MaxFileSize/DefaultBlockSize; i++ {
+		bomb = append(bomb, opCopy, 0, 0, 0, 0, 0, 0, 0, 1)
+	}
+	if _, err := Patch(base, DefaultBlockSize, bomb); !errors.Is(err, ErrDelta) {
+		t.Errorf("Patch of a copy bomb: expected ErrDelta, got %v", err)
+	}
+	if got, err := Patch(base, DefaultBlockSize, bomb[9:]); err != nil || len(got) != MaxFileSize {
+		t.Errorf("Patch up to MaxFileSize = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "src", "gen"), 0o755)
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("# build output\n*.log\n!keep.log\n/bin/\nnode_modules/\ndocs/**/*.pdf\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "src", ".gitignore"), []byte("gen/\n"), 0o644)
+	ig := NewIgnore(root)
+	ig.Load("")
+	ig.Load("src")
+
+	for rel, want := range map[string]bool{
+		"main.go":                    false,
+		"debug.log":                  true,
+		"src/debug.log":              true,
+		"keep.log":                   false,
+		"bin/tool":                   true,
+		"src/bin/tool":               false,
+		"web/node_modules/x/y.js":    true,
+		"docs/a/b/manual.pdf":        true,
+		"docs/manual.pdf":            true,
+		"src/gen/types.go":           true,
+		"src/types.go":               false,
+		".git/config":                true,
+		"a.txt.ravenpair-conflict-x": true,
+	} {
+		if got := ig.Match(rel, false); got != want {
+			t.Errorf("Match(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+// pipeSession delivers what is sent to the Syncer of the other end.
+type pipeSession struct {
+	to chan ports.Message
+}
+
+func (p *pipeSession) Events() <-chan ports.Event { return nil }
+func (p *pipeSession) Close(int, string) error    { return nil }
+func (p *pipeSession) Stats() ports.SessionStats  { return ports.SessionStats{} }
+func (p *pipeSession) Send(_ context.Context, msgType int, data []byte) error {
+	p.to <- ports.Message{Type: msgType, Data: append([]byte(nil), data...)}
+	return nil
+}
+
+// run drives a Syncer as a command would, until ctx is done.
+func run(ctx context.Context, s *Syncer, in <-chan ports.Message, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case msg := <-in:
+				s.Handle(msg)
+			case paths := <-s.Changes():
+				s.Local(paths)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func waitForFile(t *testing.T, name, want string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		got, err := os.ReadFile(name)
+		if err == nil && string(got) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestSyncerSyncsDirectories(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(dirA, ".gitignore"), []byte("*.log\n"), 0o644)
+	os.WriteFile(filepath.Join(dirA, "a.txt"), []byte("from a"), 0o644)
+	os.WriteFile(filepath.Join(dirA, "debug.log"), []byte("not synced"), 0o644)
+	os.WriteFile(filepath.Join(dirB, "b.txt"), []byte("from b"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	toA, toB := make(chan ports.Message, 64), make(chan ports.Message, 64)
+	a, err := New(ctx, &pipeSession{to: toB}, "p1", dirA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := New(ctx, &pipeSession{to: toA}, "p1", dirB, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if n := a.Start(); n != 2 {
+		t.Errorf("a.Start = %d files, want 2", n)
+	}
+	b.Start()
+	run(ctx, a, toA, &wg)
+	run(ctx, b, toB, &wg)
+
+	waitForFile(t, filepath.Join(dirB, "a.txt"), "from a")
+	waitForFile(t, filepath.Join(dirA, "b.txt"), "from b")
+	waitForFile(t, filepath.Join(dirB, ".gitignore"), "*.log\n")
+
+	os.MkdirAll(filepath.Join(dirA, "pkg"), 0o755)
+	os.WriteFile(filepath.Join(dirA, "pkg", "new.go"), []byte("package pkg\n"), 0o644)
+	os.WriteFile(filepath.Join(dirA, "a.txt"), []byte("from a, edited"), 0o644)
+	os.WriteFile(filepath.Join(dirA, "other.log"), []byte("not synced"), 0o644)
+	waitForFile(t, filepath.Join(dirB, "pkg", "new.go"), "package pkg\n")
+	waitForFile(t, filepath.Join(dirB, "a.txt"), "from a, edited")
+
+	os.Remove(filepath.Join(dirB, "b.txt"))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(filepath.Join(dirA, "b.txt")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b.txt was not deleted")
+		}
+	}
+	for _, name := range []string{"debug.log", "other.log"} {
+		if _, err := os.Stat(filepath.Join(dirB, name)); !os.IsNotExist(err) {
+			t.Errorf("expected the ignored %s not to be synced, got %v", name, err)
+		}
+	}
+}
+
+func TestSyncerConflict(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("edited here"), 0o644)
+	sent := make(chan ports.Message, 16)
+	s, err := New(context.Background(), &pipeSession{to: sent}, "p1", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var reports []Report
+	s.OnReport = func(r Report) { reports = append(reports, r) }
+	s.Start()
+	<-sent // the manifest
+
+	// The partner changed a.txt from a version this side no longer has.
+	message := func(eventType string, payload interface{}) ports.Message {
+		raw, _ := json.Marshal(payload)
+		data, _ := json.Marshal(protocol.Envelope{Type: eventType, PairID: "p1", Sender: "ana", Payload: raw})
+		return ports.Message{Type: ports.TextMessage, Data: data}
+	}
+	theirs := []byte("edited there")
+	s.Handle(message(protocol.TypeSyncChange, protocol.SyncChangePayload{Node: 9, Path: "a.txt", Base: digest([]byte("original")), Hash: digest(theirs)}))
+
+	e, err := protocol.Decode((<-sent).Data)
+	var req protocol.SyncRequestPayload
+	if err != nil || e.Type != protocol.TypeSyncRequest || e.DecodePayload(&req) != nil || req.From != 9 {
+		t.Fatalf("expected a request to the partner, got %s", e.Type)
+	}
+	var sig Signature
+	if err := sig.UnmarshalBinary(req.Signature); err != nil {
+		t.Fatal(err)
+	}
+	s.Handle(message(protocol.TypeSyncDelta, protocol.SyncDeltaPayload{Node: 9, To: 1, Path: "a.txt", Hash: digest(theirs), Delta: Delta(&sig, theirs)}))
+
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(got) != "edited here" {
+		t.Errorf("a.txt = %q; expected it to be kept", got)
+	}
+	conflict := "a.txt" + conflictMarker + "ana"
+	if got, _ := os.ReadFile(filepath.Join(dir, conflict)); string(got) != "edited there" {
+		t.Errorf("%s = %q", conflict, got)
+	}
+	if len(reports) != 1 || reports[0].Action != Conflicted || reports[0].ConflictPath != conflict || reports[0].User != "ana" {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+}
+
+func TestSyncerStaysInsideTheDirectory(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	os.WriteFile(secret, []byte("outside"), 0o644)
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("cannot create a symbolic link: %v", err)
+	}
+	sent := make(chan ports.Message, 16)
+	s, err := New(context.Background(), &pipeSession{to: sent}, "p1", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var reports []Report
+	s.OnReport = func(r Report) { reports = append(reports, r) }
+	s.Start()
+	<-sent // the manifest
+
+	message := func(eventType string, payload interface{}) ports.Message {
+		raw, _ := json.Marshal(payload)
+		data, _ := json.Marshal(protocol.Envelope{Type: eventType, PairID: "p1", Sender: "ana", Payload: raw})
+		return ports.Message{Type: ports.TextMessage, Data: data}
+	}
+	evil := []byte("written by ana")
+	s.Handle(message(protocol.TypeSyncChange, protocol.SyncChangePayload{Node: 9, Path: "link/secret.txt", Base: digest([]byte("outside"))}))
+	s.Handle(message(protocol.TypeSyncManifest, protocol.SyncManifestPayload{Node: 9, Files: map[string]string{"link/new.txt": digest(evil)}, Reply: true}))
+	s.Handle(message(protocol.TypeSyncDelta, protocol.SyncDeltaPayload{Node: 9, To: 1, Path: "link/new.txt", Hash: digest(evil), Delta: Delta(&Signature{BlockSize: DefaultBlockSize}, evil)}))
+	s.Handle(message(protocol.TypeSyncRequest, protocol.SyncRequestPayload{Node: 9, From: 1, Path: "link/secret.txt", Hash: digest([]byte("outside"))}))
+
+	if got, err := os.ReadFile(secret); err != nil || string(got) != "outside" {
+		t.Errorf("secret.txt = %q, %v; expected it to be left alone", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written outside the directory, got %v", err)
+	}
+	for len(sent) > 0 {
+		if e, err := protocol.Decode((<-sent).Data); err == nil && e.Type == protocol.TypeSyncDelta {
+			t.Error("expected a file outside the directory not to be sent")
+		}
+	}
+	for _, r := range reports {
+		if r.Action != Failed || !errors.Is(r.Err, errSymlink) {
+			t.Errorf("unexpected report %+v", r)
+		}
+	}
+	if len(reports) != 2 {
+		t.Errorf("expected the deletion and the write to fail, got %+v", reports)
+	}
+}
@@ -0,0 +1,136 @@
+package dirsync
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore tells which paths of a directory tree are left out of syncing:
+// those its .gitignore files exclude, the .git directory, and the files
+// syncing itself creates.
+type Ignore struct {
+	root string
+	// rules holds the rules of each .gitignore, by the slash path of its
+	// directory relative to root; the root is "".
+	rules map[string][]ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns match the whole path from their .gitignore's
+	// directory rather than any name in it.
+	anchored bool
+}
+
+// NewIgnore returns an Ignore for the tree at root, with no .gitignore
+// loaded yet.
+func NewIgnore(root string) *Ignore {
+	return &Ignore{root: root, rules: make(map[string][]ignoreRule)}
+}
+
+// Load reads the .gitignore of dir, a slash path relative to the root,
+// replacing the rules read from it before.
+func (ig *Ignore) Load(dir string) {
+	delete(ig.rules, dir)
+	f, err := os.Open(filepath.Join(ig.root, filepath.FromSlash(dir), ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := strings.TrimRight(lines.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		r.anchored = strings.Contains(line, "/")
+		r.pattern = strings.TrimPrefix(line, "/")
+		if r.pattern != "" {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) > 0 {
+		ig.rules[dir] = rules
+	}
+}
+
+// Match reports whether rel, a slash path relative to the root, is ignored.
+// As in git, a path is ignored when any directory above it is.
+func (ig *Ignore) Match(rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if ig.matchOne(strings.Join(parts[:i+1], "/"), i < len(parts)-1 || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ig *Ignore) matchOne(rel string, isDir bool) bool {
+	name := path.Base(rel)
+	if name == ".git" || isSyncFile(name) {
+		return true
+	}
+	ignored := false
+	// Rules of deeper .gitignore files come later and take precedence.
+	dirs := strings.Split(rel, "/")
+	for i := range dirs {
+		dir := strings.Join(dirs[:i], "/")
+		sub := strings.Join(dirs[i:], "/")
+		for _, r := range ig.rules[dir] {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			target := name
+			if r.anchored {
+				target = sub
+			}
+			if matchGlob(r.pattern, target) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// matchGlob matches a slash path against a pattern whose ** components
+// stand for any number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,652 @@
+// Package dirsync keeps a directory in sync between the participants of a
+// pair session. A changed file travels as an rsync-style delta against the
+// partner's copy, and a file changed on both sides at once is kept apart as
+// a conflict rather than overwritten.
+package dirsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+const (
+	// MaxFileSize is the size of the largest file synced.
+	MaxFileSize = 8 << 20
+	// settle is how long changes must stop before they are synced, so that
+	// a file being written is sent once.
+	settle = 100 * time.Millisecond
+	// conflictMarker names the file a conflicting version is saved to:
+	// path + conflictMarker + the name of its author.
+	conflictMarker = ".ravenpair-conflict-"
+	// tempPrefix names the files written before they replace synced files.
+	tempPrefix = ".ravenpair-sync-"
+)
+
+var (
+	errTooLarge = errors.New("the file is too large to sync")
+	// errSymlink refuses a path leading through a symbolic link, which could
+	// point outside the directory.
+	errSymlink = errors.New("the path leads through a symbolic link")
+)
+
+// isSyncFile reports whether name is a file that syncing itself creates.
+func isSyncFile(name string) bool {
+	return strings.Contains(name, conflictMarker) || strings.HasPrefix(name, tempPrefix)
+}
+
+// Action is what a Report is about.
+type Action int
+
+const (
+	// Published is a change made here and sent to the pair.
+	Published Action = iota
+	// Applied is a change of a partner applied here.
+	Applied
+	// Conflicted is a change of a partner to a file also changed here,
+	// which was not applied.
+	Conflicted
+	// Skipped is a file left out of syncing.
+	Skipped
+	// Failed is a change that could not be synced.
+	Failed
+)
+
+// Report tells what happened to a file.
+type Report struct {
+	Action Action
+	Path   string
+	// User made the change; it is empty for changes made here.
+	User string
+	// Deleted is set when the change deleted the file.
+	Deleted bool
+	// ConflictPath is where a partner's conflicting version was saved.
+	ConflictPath string
+	Err          error
+}
+
+// fetch is a version of a file asked for and not received yet.
+type fetch struct {
+	hash string
+	from uint32
+	user string
+	// base is the digest of the copy whose signature was sent.
+	base     string
+	conflict bool
+	retried  bool
+}
+
+// Syncer syncs a directory. Handle and Local must be called from a single
+// goroutine, which Report is called on too.
+type Syncer struct {
+	ctx    context.Context
+	sess   ports.Session
+	pairID string
+	node   uint32
+	root   string
+	ignore *Ignore
+
+	watcher *fsnotify.Watcher
+	changes chan []string
+	done    chan struct{}
+
+	// OnReport is called with what happens to each file.
+	OnReport func(Report)
+
+	// local holds the digest of each file here as last seen, and shared the
+	// last version of each file announced in the session.
+	local, shared map[string]string
+	pending       map[string]*fetch
+	skipped       map[string]bool
+}
+
+// New returns a Syncer for the directory root, sending over sess. node
+// identifies this side in the messages it sends.
+func New(ctx context.Context, sess ports.Session, pairID, root string, node uint32) (*Syncer, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &Syncer{
+		ctx:      ctx,
+		sess:     sess,
+		pairID:   pairID,
+		node:     node,
+		root:     root,
+		ignore:   NewIgnore(root),
+		watcher:  w,
+		changes:  make(chan []string),
+		done:     make(chan struct{}),
+		OnReport: func(Report) {},
+		local:    make(map[string]string),
+		shared:   make(map[string]string),
+		pending:  make(map[string]*fetch),
+		skipped:  make(map[string]bool),
+	}, nil
+}
+
+// Start reads the directory, starts watching it and announces its files to
+// the pair. It returns how many files there are.
+func (s *Syncer) Start() int {
+	s.scan("", false)
+	for rel, hash := range s.local {
+		s.shared[rel] = hash
+	}
+	go s.watch()
+	s.send(protocol.TypeSyncManifest, protocol.SyncManifestPayload{Node: s.node, Files: s.local})
+	return len(s.local)
+}
+
+// Changes delivers the paths changed here, a batch at a time, for Local.
+func (s *Syncer) Changes() <-chan []string { return s.changes }
+
+// Close stops watching the directory.
+func (s *Syncer) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+		s.watcher.Close()
+	}
+}
+
+// scan reads the directory rel and those below it, watching each. With
+// publish, it announces the files that changed; otherwise it just records
+// them.
+func (s *Syncer) scan(rel string, publish bool) {
+	dir := s.abs(rel)
+	s.ignore.Load(rel)
+	if err := s.watcher.Add(dir); err != nil {
+		s.OnReport(Report{Action: Failed, Path: rel, Err: err})
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		child := path.Join(rel, e.Name())
+		if s.ignore.Match(child, e.IsDir()) {
+			continue
+		}
+		switch {
+		case e.IsDir():
+			s.scan(child, publish)
+		case !e.Type().IsRegular():
+		case publish:
+			s.localChange(child)
+		default:
+			if _, hash, err := s.read(child); err == nil {
+				s.local[child] = hash
+			} else {
+				s.skip(child, err)
+			}
+		}
+	}
+}
+
+// watch collects the changes fsnotify reports, delivering them once they
+// settle.
+func (s *Syncer) watch() {
+	batch := make(map[string]bool)
+	var settled <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if rel, ok := s.rel(ev.Name); ok && rel != "" {
+				batch[rel] = true
+				settled = time.After(settle)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Changes may have been lost; look at everything again.
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				batch[""] = true
+				settled = time.After(settle)
+			}
+		case <-settled:
+			paths := make([]string, 0, len(batch))
+			for p := range batch {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			clear(batch)
+			settled = nil
+			select {
+			case s.changes <- paths:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Local syncs the paths changed here, as delivered by Changes.
+func (s *Syncer) Local(paths []string) {
+	for _, rel := range paths {
+		s.localChange(rel)
+	}
+}
+
+func (s *Syncer) localChange(rel string) {
+	if rel == "" {
+		s.scan("", true)
+		return
+	}
+	fi, err := os.Lstat(s.abs(rel))
+	if err == nil && fi.IsDir() {
+		if !s.ignore.Match(rel, true) {
+			s.scan(rel, true)
+		}
+		return
+	}
+	if path.Base(rel) == ".gitignore" {
+		s.ignore.Load(parentDir(rel))
+	}
+	if s.ignore.Match(rel, false) {
+		return
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		// Deleted: the file, or everything under the directory it was.
+		for p := range s.local {
+			if p == rel || strings.HasPrefix(p, rel+"/") {
+				s.publish(p, "")
+			}
+		}
+		return
+	}
+	_, hash, err := s.read(rel)
+	if err != nil {
+		s.skip(rel, err)
+		return
+	}
+	delete(s.skipped, rel)
+	if hash != s.local[rel] {
+		s.publish(rel, hash)
+	}
+}
+
+// publish announces a change made here.
+func (s *Syncer) publish(rel, hash string) {
+	base := s.shared[rel]
+	if hash == "" {
+		delete(s.local, rel)
+	} else {
+		s.local[rel] = hash
+	}
+	s.shared[rel] = hash
+	s.send(protocol.TypeSyncChange, protocol.SyncChangePayload{Node: s.node, Path: rel, Base: base, Hash: hash})
+	s.OnReport(Report{Action: Published, Path: rel, Deleted: hash == ""})
+}
+
+// Handle processes a message of the session, returning false when it is not
+// about syncing.
+func (s *Syncer) Handle(msg ports.Message) bool {
+	if msg.Type != ports.TextMessage {
+		return false
+	}
+	e, err := protocol.Decode(msg.Data)
+	if err != nil {
+		return false
+	}
+	user := e.Sender
+	switch e.Type {
+	case protocol.TypeSyncManifest:
+		var p protocol.SyncManifestPayload
+		if e.DecodePayload(&p) == nil && p.Node != s.node {
+			s.manifest(p, user)
+		}
+	case protocol.TypeSyncChange:
+		var p protocol.SyncChangePayload
+		if e.DecodePayload(&p) == nil && p.Node != s.node && s.syncable(p.Path) {
+			s.change(p, user)
+		}
+	case protocol.TypeSyncRequest:
+		var p protocol.SyncRequestPayload
+		if e.DecodePayload(&p) == nil && p.From == s.node && s.syncable(p.Path) {
+			s.answer(p)
+		}
+	case protocol.TypeSyncDelta:
+		var p protocol.SyncDeltaPayload
+		if e.DecodePayload(&p) == nil && p.To == s.node && s.syncable(p.Path) {
+			s.delta(p)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// manifest fetches the files a participant has that are missing here, and
+// those that differ as conflicts, since nothing tells which is newer.
+func (s *Syncer) manifest(p protocol.SyncManifestPayload, user string) {
+	paths := make([]string, 0, len(p.Files))
+	for rel := range p.Files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	for _, rel := range paths {
+		hash := p.Files[rel]
+		if hash == "" || !s.syncable(rel) {
+			continue
+		}
+		switch cur := s.local[rel]; {
+		case cur == hash:
+			s.shared[rel] = hash
+		case s.shared[rel] == hash:
+			// Known already, as a conflict.
+		case cur == "":
+			s.shared[rel] = hash
+			s.fetch(rel, hash, p.Node, user, false)
+		default:
+			s.shared[rel] = hash
+			s.fetch(rel, hash, p.Node, user, true)
+		}
+	}
+	if !p.Reply {
+		s.send(protocol.TypeSyncManifest, protocol.SyncManifestPayload{Node: s.node, Files: s.local, Reply: true})
+	}
+}
+
+// change applies a change of a participant, unless the file changed here
+// too.
+func (s *Syncer) change(p protocol.SyncChangePayload, user string) {
+	_, cur, err := s.read(p.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		cur, err = "", nil
+	}
+	if err != nil {
+		s.OnReport(Report{Action: Failed, Path: p.Path, User: user, Err: err})
+		return
+	}
+	if cur != p.Hash && cur != s.local[p.Path] {
+		// Changed here but not announced yet: announce it first, so that the
+		// participant sees the conflict too.
+		s.publish(p.Path, cur)
+	}
+	s.shared[p.Path] = p.Hash
+	switch {
+	case cur == p.Hash:
+		s.setLocal(p.Path, cur)
+	case cur != p.Base && p.Hash == "":
+		s.OnReport(Report{Action: Conflicted, Path: p.Path, User: user, Deleted: true})
+	case cur != p.Base:
+		s.fetch(p.Path, p.Hash, p.Node, user, true)
+	case p.Hash == "":
+		delete(s.pending, p.Path)
+		name, err := s.inside(p.Path)
+		if err == nil {
+			err = os.Remove(name)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.OnReport(Report{Action: Failed, Path: p.Path, User: user, Err: err})
+			return
+		}
+		delete(s.local, p.Path)
+		s.OnReport(Report{Action: Applied, Path: p.Path, User: user, Deleted: true})
+	default:
+		s.fetch(p.Path, p.Hash, p.Node, user, false)
+	}
+}
+
+// fetch asks the participant from for version hash of a file, sending the
+// signature of the copy here. A conflicting version is saved next to the
+// file rather than over it.
+func (s *Syncer) fetch(rel, hash string, from uint32, user string, conflict bool) {
+	data, base, err := s.read(rel)
+	if err != nil {
+		data, base = nil, ""
+	}
+	req := protocol.SyncRequestPayload{Node: s.node, From: from, Path: rel, Hash: hash}
+	if len(data) > 0 {
+		req.Signature, _ = NewSignature(data, DefaultBlockSize).MarshalBinary()
+	}
+	s.pending[rel] = &fetch{hash: hash, from: from, user: user, base: base, conflict: conflict}
+	s.send(protocol.TypeSyncRequest, req)
+}
+
+// answer sends the delta a participant asked for, from the file as it is
+// now.
+func (s *Syncer) answer(p protocol.SyncRequestPayload) {
+	data, hash, err := s.read(p.Path)
+	if err != nil {
+		return
+	}
+	sig := &Signature{BlockSize: DefaultBlockSize}
+	if len(p.Signature) > 0 && sig.UnmarshalBinary(p.Signature) != nil {
+		return
+	}
+	s.send(protocol.TypeSyncDelta, protocol.SyncDeltaPayload{Node: s.node, To: p.Node, Path: p.Path, Hash: hash, Delta: Delta(sig, data)})
+}
+
+// delta applies the delta a participant sent.
+func (s *Syncer) delta(p protocol.SyncDeltaPayload) {
+	f := s.pending[p.Path]
+	if f == nil || f.hash != p.Hash {
+		return // a newer version was announced since
+	}
+	delete(s.pending, p.Path)
+	base, cur, err := s.read(p.Path)
+	if err != nil {
+		base, cur = nil, ""
+	}
+	if cur != f.base {
+		// The file changed here meanwhile: that is a conflict too.
+		if f.retried {
+			s.OnReport(Report{Action: Failed, Path: p.Path, User: f.user, Err: errors.New("the file kept changing")})
+			return
+		}
+		s.fetch(p.Path, f.hash, f.from, f.user, true)
+		s.pending[p.Path].retried = true
+		return
+	}
+	data, err := Patch(base, DefaultBlockSize, p.Delta)
+	if err == nil && digest(data) != p.Hash {
+		err = ErrDelta
+	}
+	if err != nil {
+		s.OnReport(Report{Action: Failed, Path: p.Path, User: f.user, Err: err})
+		return
+	}
+	if f.conflict {
+		name := p.Path + conflictMarker + safeUser(f.user)
+		if err := s.write(name, data); err != nil {
+			s.OnReport(Report{Action: Failed, Path: p.Path, User: f.user, Err: err})
+			return
+		}
+		s.OnReport(Report{Action: Conflicted, Path: p.Path, User: f.user, ConflictPath: name})
+		return
+	}
+	if err := s.write(p.Path, data); err != nil {
+		s.OnReport(Report{Action: Failed, Path: p.Path, User: f.user, Err: err})
+		return
+	}
+	s.setLocal(p.Path, p.Hash)
+	s.OnReport(Report{Action: Applied, Path: p.Path, User: f.user})
+}
+
+func (s *Syncer) setLocal(rel, hash string) {
+	if hash == "" {
+		delete(s.local, rel)
+	} else {
+		s.local[rel] = hash
+	}
+}
+
+// skip reports a file left out of syncing, once.
+func (s *Syncer) skip(rel string, err error) {
+	if !s.skipped[rel] {
+		s.skipped[rel] = true
+		s.OnReport(Report{Action: Skipped, Path: rel, Err: err})
+	}
+}
+
+// read returns the content of a file and its digest.
+func (s *Syncer) read(rel string) ([]byte, string, error) {
+	name, err := s.inside(rel)
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, "", fmt.Errorf("%s is not a regular file", rel)
+	}
+	if fi.Size() > MaxFileSize {
+		return nil, "", errTooLarge
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, digest(data), nil
+}
+
+// write replaces a file atomically, keeping its permissions.
+func (s *Syncer) write(rel string, data []byte) error {
+	name, err := s.inside(rel)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if fi, err := os.Lstat(name); err == nil && fi.Mode().IsRegular() {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// syncable reports whether rel, received from a participant, is a path
+// inside the directory that is not ignored here.
+func (s *Syncer) syncable(rel string) bool {
+	return rel != "" && path.Clean(rel) == rel && filepath.IsLocal(filepath.FromSlash(rel)) &&
+		!strings.Contains(rel, `\`) && !s.ignore.Match(rel, false)
+}
+
+func (s *Syncer) abs(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// inside returns the path of rel after checking that none of the directories
+// leading to it is a symbolic link, so that what is read, written or removed
+// for a participant stays inside the directory. Directories that do not
+// exist yet are fine: write creates them.
+func (s *Syncer) inside(rel string) (string, error) {
+	dir := s.root
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", errSymlink
+		}
+	}
+	return s.abs(rel), nil
+}
+
+// rel returns the slash path of name relative to the root.
+func (s *Syncer) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (s *Syncer) send(eventType string, payload interface{}) {
+	data, err := protocol.Encode(eventType, s.pairID, payload)
+	if err != nil {
+		return
+	}
+	_ = s.sess.Send(s.ctx, ports.TextMessage, data)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// parentDir returns the slash path of the directory of rel, "" for the
+// root.
+func parentDir(rel string) string {
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// safeUser makes a user name fit in a file name.
+func safeUser(user string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, user)
+	if name == "" {
+		return "partner"
+	}
+	return name
+}
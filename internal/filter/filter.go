@@ -0,0 +1,188 @@
+// Package filter runs jq expressions over JSON messages, using an embedded
+// jq implementation so no external binary is needed.
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// Program is a compiled jq expression.
+type Program struct {
+	expr string
+	code *gojq.Code
+}
+
+// Compile parses and compiles the jq expression expr.
+func Compile(expr string) (*Program, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression %q: %w", expr, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression %q: %w", expr, err)
+	}
+	return &Program{expr: expr, code: code}, nil
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.expr
+}
+
+// Run evaluates p with input v, which must be a value as decoded by
+// encoding/json into an interface{}, and returns every output.
+func (p *Program) Run(ctx context.Context, v interface{}) ([]interface{}, error) {
+	var outs []interface{}
+	iter := p.code.RunWithContext(ctx, v)
+	for {
+		out, ok := iter.Next()
+		if !ok {
+			return outs, nil
+		}
+		if err, ok := out.(error); ok {
+			return nil, fmt.Errorf("jq %q: %w", p.expr, err)
+		}
+		outs = append(outs, out)
+	}
+}
+
+// RunJSON decodes data, evaluates p on it and returns every output encoded as
+// compact JSON, the way jq -c prints them. It fails if data is not JSON.
+func (p *Program) RunJSON(ctx context.Context, data []byte) ([][]byte, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	outs, err := p.Run(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return encode(outs)
+}
+
+// ErrNotJSON is wrapped by the error of RunJSON when the input is not a JSON
+// document.
+var ErrNotJSON = errors.New("not a JSON document")
+
+func decode(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotJSON, err)
+	}
+	return v, nil
+}
+
+func encode(outs []interface{}) ([][]byte, error) {
+	encoded := make([][]byte, 0, len(outs))
+	for _, out := range outs {
+		b, err := gojq.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	return encoded, nil
+}
+
+// NonJSONPolicy decides what a Pipeline does with messages that are not JSON,
+// including binary messages.
+type NonJSONPolicy int
+
+const (
+	// NonJSONPass lets such messages through unchanged.
+	NonJSONPass NonJSONPolicy = iota
+	// NonJSONDrop discards them.
+	NonJSONDrop
+)
+
+var nonJSONPolicyNames = []string{"pass", "drop"}
+
+func (p NonJSONPolicy) String() string {
+	if int(p) >= 0 && int(p) < len(nonJSONPolicyNames) {
+		return nonJSONPolicyNames[p]
+	}
+	return fmt.Sprintf("NonJSONPolicy(%d)", int(p))
+}
+
+// ParseNonJSONPolicy parses the name of a NonJSONPolicy, as returned by its
+// String method.
+func ParseNonJSONPolicy(name string) (NonJSONPolicy, error) {
+	for i, n := range nonJSONPolicyNames {
+		if n == name {
+			return NonJSONPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown non-JSON policy %q: use one of %s", name, strings.Join(nonJSONPolicyNames, ", "))
+}
+
+// Pipeline selects and rewrites a stream of messages.
+type Pipeline struct {
+	// Filter keeps a message when it yields at least one value other than
+	// false and null, so both `.type == "chat"` and `select(.type == "chat")`
+	// work. A nil Filter keeps every message.
+	Filter *Program
+	// Transform replaces a kept message with each of its outputs. A nil
+	// Transform leaves messages unchanged.
+	Transform *Program
+	// NonJSON decides what happens to messages that are not JSON.
+	NonJSON NonJSONPolicy
+}
+
+// Active reports whether the pipeline changes anything at all.
+func (p *Pipeline) Active() bool {
+	return p != nil && (p.Filter != nil || p.Transform != nil)
+}
+
+// Apply runs a text message through the pipeline and returns the messages to
+// use in its place: none when it was filtered out, one or more otherwise.
+func (p *Pipeline) Apply(ctx context.Context, data []byte) ([][]byte, error) {
+	if !p.Active() {
+		return [][]byte{data}, nil
+	}
+	v, err := decode(data)
+	if err != nil {
+		if p.KeepNonJSON() {
+			return [][]byte{data}, nil
+		}
+		return nil, nil
+	}
+	if p.Filter != nil {
+		outs, err := p.Filter.Run(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		if !anyTruthy(outs) {
+			return nil, nil
+		}
+	}
+	if p.Transform == nil {
+		return [][]byte{data}, nil
+	}
+	outs, err := p.Transform.Run(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return encode(outs)
+}
+
+// KeepNonJSON reports whether a message that is not JSON, such as a binary
+// message, passes the pipeline unchanged.
+func (p *Pipeline) KeepNonJSON() bool {
+	return !p.Active() || p.NonJSON == NonJSONPass
+}
+
+func anyTruthy(outs []interface{}) bool {
+	for _, out := range outs {
+		if out != nil && out != false {
+			return true
+		}
+	}
+	return false
+}
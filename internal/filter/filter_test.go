@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func mustCompile(t *testing.T, expr string) *Program {
+	t.Helper()
+	p, err := Compile(expr)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", expr, err)
+	}
+	return p
+}
+
+func TestCompileRejectsInvalidExpressions(t *testing.T) {
+	if _, err := Compile(".type =="); err == nil {
+		t.Error("expected a syntax error")
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	outs, err := mustCompile(t, ".items[] | {name}").RunJSON(context.Background(), []byte(`{"items":[{"name":"a","x":1},{"name":"b"}]}`))
+	if err != nil {
+		t.Fatalf("RunJSON: %v", err)
+	}
+	if len(outs) != 2 || string(outs[0]) != `{"name":"a"}` || string(outs[1]) != `{"name":"b"}` {
+		t.Errorf("unexpected outputs: %q", outs)
+	}
+
+	if _, err := mustCompile(t, ".").RunJSON(context.Background(), []byte("not json")); !errors.Is(err, ErrNotJSON) {
+		t.Errorf("expected ErrNotJSON, got %v", err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	p := &Pipeline{
+		Filter:    mustCompile(t, `select(.type == "chat")`),
+		Transform: mustCompile(t, ".payload.text, .sender"),
+	}
+	ctx := context.Background()
+
+	msgs, err := p.Apply(ctx, []byte(`{"type":"chat","sender":"ana","payload":{"text":"hi"}}`))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(msgs) != 2 || string(msgs[0]) != `"hi"` || string(msgs[1]) != `"ana"` {
+		t.Errorf("unexpected messages: %q", msgs)
+	}
+
+	if msgs, _ := p.Apply(ctx, []byte(`{"type":"cursor"}`)); len(msgs) != 0 {
+		t.Errorf("expected the message to be filtered out, got %q", msgs)
+	}
+	if msgs, _ := p.Apply(ctx, []byte("raw")); len(msgs) != 1 || string(msgs[0]) != "raw" {
+		t.Errorf("expected non-JSON to pass, got %q", msgs)
+	}
+
+	p.NonJSON = NonJSONDrop
+	if msgs, _ := p.Apply(ctx, []byte("raw")); len(msgs) != 0 {
+		t.Errorf("expected non-JSON to be dropped, got %q", msgs)
+	}
+}
+
+func TestPipelineFilterTruthiness(t *testing.T) {
+	p := &Pipeline{Filter: mustCompile(t, ".n > 1")}
+	for in, keep := range map[string]bool{`{"n":2}`: true, `{"n":1}`: false} {
+		msgs, err := p.Apply(context.Background(), []byte(in))
+		if err != nil {
+			t.Fatalf("Apply(%s): %v", in, err)
+		}
+		if (len(msgs) == 1) != keep {
+			t.Errorf("Apply(%s) = %q, keep=%v", in, msgs, keep)
+		}
+	}
+}
+
+func TestInactivePipelineKeepsEverything(t *testing.T) {
+	var p *Pipeline
+	if !p.KeepNonJSON() {
+		t.Error("a nil pipeline must keep non-JSON messages")
+	}
+	msgs, err := p.Apply(context.Background(), []byte("x"))
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("unexpected result %q, %v", msgs, err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package knownservers implements SSH-style trust-on-first-use for server
+// certificates. The fingerprint of the first certificate seen for a host is
+// recorded in a known_servers file and every later connection must present
+// the same certificate.
+package knownservers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultPath returns the location of the user's known_servers file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ravenpair", "known_servers"), nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert in the form recorded in
+// the known_servers file.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// MismatchError is returned when a host presents a certificate that differs
+// from the one recorded for it.
+type MismatchError struct {
+	Host  string
+	Known string
+	Got   string
+	Path  string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("certificate for %s has changed (recorded %s, got %s)", e.Host, e.Known, e.Got)
+}
+
+// Warning returns a prominent multi-line explanation suitable for stderr.
+func (e *MismatchError) Warning() string {
+	const bar = "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@"
+	return fmt.Sprintf(`%[1]s
+@    WARNING: REMOTE SERVER IDENTIFICATION HAS CHANGED!     @
+%[1]s
+IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!
+Someone could be eavesdropping on you right now (man-in-the-middle attack)!
+It is also possible that the server certificate has just been replaced.
+The certificate fingerprint for %[2]s is now
+    %[3]s
+but %[4]s records
+    %[5]s
+If you trust the new certificate, run:
+    ravenpair servers forget %[2]s
+The connection was aborted.`, bar, e.Host, e.Got, e.Path, e.Known)
+}
+
+// Store reads and updates a known_servers file. Each line holds a host:port
+// and the certificate fingerprint recorded for it; lines starting with # are
+// comments. A Store is safe for concurrent use.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New returns a Store backed by the file at path, which need not exist yet.
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the location of the backing file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Entries returns the recorded fingerprints keyed by host:port.
+func (s *Store) Entries() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Verifier returns a callback for transport.TLSOptions.TrustOnFirstUse that
+// checks the server's leaf certificate against the fingerprint recorded for
+// hostPort, recording it when the host is seen for the first time.
+func (s *Store) Verifier(hostPort string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("server %s presented no certificate", hostPort)
+		}
+		return s.check(hostPort, Fingerprint(cs.PeerCertificates[0]))
+	}
+}
+
+func (s *Store) check(hostPort, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	known, ok := entries[hostPort]
+	if !ok {
+		return s.append(hostPort, fingerprint)
+	}
+	if known != fingerprint {
+		return &MismatchError{Host: hostPort, Known: known, Got: fingerprint, Path: s.path}
+	}
+	return nil
+}
+
+// Forget removes the entries for host, which is either a host:port or a bare
+// host name matching every port. It returns the number of entries removed.
+func (s *Store) Forget(host string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for hostPort := range entries {
+		if hostPort == host || hostOf(hostPort) == host {
+			delete(entries, hostPort)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.write(entries)
+}
+
+func hostOf(hostPort string) string {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return host
+}
+
+func (s *Store) read() (map[string]string, error) {
+	entries := map[string]string{}
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading known servers: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		entries[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading known servers: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *Store) append(hostPort, fingerprint string) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("recording known server: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("recording known server: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", hostPort, fingerprint); err != nil {
+		return fmt.Errorf("recording known server: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) write(entries map[string]string) error {
+	hosts := make([]string, 0, len(entries))
+	for h := range entries {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	var b strings.Builder
+	for _, h := range hosts {
+		fmt.Fprintf(&b, "%s %s\n", h, entries[h])
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("updating known servers: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("updating known servers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package knownservers
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// dial connects to srv, whose certificate no trusted CA signs.
+func dial(t *testing.T, srv *httptest.Server, verify func(tls.ConnectionState) error) error {
+	t.Helper()
+	cfg := &tls.Config{InsecureSkipVerify: true, VerifyConnection: verify}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifierRecordsOnFirstUse(t *testing.T) {
+	store := New(filepath.Join(t.TempDir(), "ravenpair", "known_servers"))
+	srv := newServer(t)
+
+	if err := dial(t, srv, store.Verifier("example.com:443")); err != nil {
+		t.Fatalf("first connection should be trusted, got: %v", err)
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if entries["example.com:443"] != Fingerprint(srv.Certificate()) {
+		t.Errorf("expected fingerprint to be recorded, got %v", entries)
+	}
+
+	if err := dial(t, srv, store.Verifier("example.com:443")); err != nil {
+		t.Errorf("same certificate should be accepted, got: %v", err)
+	}
+}
+
+func TestVerifierRejectsChangedCertificate(t *testing.T) {
+	store := New(filepath.Join(t.TempDir(), "known_servers"))
+	if err := store.check("example.com:443", "SHA256:previous"); err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(t)
+
+	err := dial(t, srv, store.Verifier("example.com:443"))
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a MismatchError, got: %v", err)
+	}
+	if mismatch.Known != "SHA256:previous" || mismatch.Got != Fingerprint(srv.Certificate()) {
+		t.Errorf("unexpected mismatch details: %+v", mismatch)
+	}
+}
+
+func TestForget(t *testing.T) {
+	store := New(filepath.Join(t.TempDir(), "known_servers"))
+	for _, host := range []string{"example.com:443", "example.com:8443", "other.com:443"} {
+		if err := store.check(host, "SHA256:abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := store.Forget("example.com")
+	if err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 entries removed, got %d", removed)
+	}
+
+	removed, err = store.Forget("other.com:443")
+	if err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 entry removed, got %d", removed)
+	}
+
+	entries, _ := store.Entries()
+	if len(entries) != 0 {
+		t.Errorf("expected no entries left, got %v", entries)
+	}
+}
@@ -1,13 +1,209 @@
 package ports
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
 
-// MessageHandler is called for every message received from the WebSocket server.
-type MessageHandler func(msgType int, data []byte)
+// Message types, matching the WebSocket opcodes of RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
 
-// WSClient is the outgoing port for a persistent WebSocket connection.
+// Close status codes of RFC 6455 that callers act upon.
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseNoStatus        = 1005
+	CloseAbnormalClosure = 1006
+	ClosePolicyViolation = 1008
+)
+
+// DialOptions configures a single WebSocket session.
+type DialOptions struct {
+	// Headers are sent with the opening handshake.
+	Headers map[string]string
+	// CloseTimeout bounds how long Session.Close waits for the server to
+	// answer the closing handshake. Zero selects the adapter's default.
+	CloseTimeout time.Duration
+	// MaxMessageSize is the largest message accepted from the server, in
+	// bytes. A larger message ends the session with ErrMessageTooBig. Zero
+	// means no limit.
+	MaxMessageSize int64
+	// StreamThreshold is the size above which a message is delivered as a
+	// Stream event instead of being buffered in memory. Zero buffers every
+	// message.
+	StreamThreshold int64
+	// QueueSize is how many received messages may wait for the consumer of
+	// Session.Events. Zero selects the adapter's default.
+	QueueSize int
+	// Overflow decides what happens to new messages when the queue is full.
+	Overflow OverflowPolicy
+	// Subprotocols lists the application protocols offered to the server, in
+	// order of preference. When set, Open fails unless the server selects one.
+	Subprotocols []string
+	// Compression requests permessage-deflate; Open fails unless the server
+	// accepts it. CompressionLevel ranges from -2 (Huffman only) through 0
+	// (none) to 9 (best compression); nil selects the adapter's default.
+	Compression      bool
+	CompressionLevel *int
+}
+
+// ErrNegotiation is returned by WSClient.Open when the server did not accept
+// a required subprotocol or extension.
+var ErrNegotiation = errors.New("WebSocket negotiation failed")
+
+// OverflowPolicy decides what happens when the consumer of Session.Events
+// falls behind and the message queue is full. Lifecycle events are never
+// dropped.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading from the connection until there is room.
+	// Nothing is lost, but pings go unanswered while the consumer is stuck.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message.
+	OverflowDropOldest
+	// OverflowDropNewest discards the message that did not fit.
+	OverflowDropNewest
+	// OverflowDisconnect ends the session with ErrQueueOverflow.
+	OverflowDisconnect
+)
+
+var overflowPolicyNames = []string{"block", "drop-oldest", "drop-newest", "disconnect"}
+
+func (p OverflowPolicy) String() string {
+	if int(p) >= 0 && int(p) < len(overflowPolicyNames) {
+		return overflowPolicyNames[p]
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy parses the name of an OverflowPolicy, as returned by its
+// String method.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for i, n := range overflowPolicyNames {
+		if n == name {
+			return OverflowPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q: use one of %s", name, strings.Join(overflowPolicyNames, ", "))
+}
+
+// SessionStats counts the messages a session has received.
+type SessionStats struct {
+	// Received counts every message read from the connection.
+	Received uint64
+	// Dropped counts messages discarded by the overflow policy.
+	Dropped uint64
+}
+
+// ErrMessageTooBig is reported when the server sends a message larger than
+// DialOptions.MaxMessageSize.
+var ErrMessageTooBig = errors.New("message exceeds the maximum size")
+
+// ErrQueueOverflow is reported when the message queue overflows under the
+// OverflowDisconnect policy.
+var ErrQueueOverflow = errors.New("message queue overflowed")
+
+// HandshakeError is returned by WSClient.Open when the server answered the
+// opening handshake with an HTTP error status instead of upgrading.
+type HandshakeError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake rejected with HTTP %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// WSClient is the outgoing port for persistent WebSocket connections.
 type WSClient interface {
-	// Dial connects to wsURL, forwarding headers, and calls onMessage for each
-	// message until ctx is cancelled or the connection is closed.
-	Dial(ctx context.Context, wsURL string, headers map[string]string, onMessage MessageHandler) error
+	// Open performs the opening handshake with wsURL and returns the
+	// established session. ctx bounds the handshake only; once open, the
+	// session lives until it is closed by either side.
+	Open(ctx context.Context, wsURL string, opts DialOptions) (Session, error)
+}
+
+// Session is an open, bidirectional WebSocket connection.
+type Session interface {
+	// Events delivers lifecycle events and received messages in order,
+	// starting with Opened. The channel is closed after the final Closed or
+	// Error event.
+	Events() <-chan Event
+	// Send writes a message of the given type (TextMessage or BinaryMessage).
+	// It gives up when ctx is done, whether the message is still waiting for
+	// another Send or being written; a message cut short ends the session.
+	Send(ctx context.Context, msgType int, data []byte) error
+	// Close starts the closing handshake with the given status code and
+	// reason and returns without blocking. The session ends with a Closed
+	// event once the server answers, or once DialOptions.CloseTimeout has
+	// passed, in which case the event carries CloseAbnormalClosure. Events
+	// that the consumer does not take within CloseTimeout of Close are
+	// discarded, so a consumer may stop reading once it has closed.
+	Close(code int, reason string) error
+	// Stats returns the session's message counters so far.
+	Stats() SessionStats
+}
+
+// Event is a lifecycle event or message delivered by Session.Events. It is
+// one of Opened, Message, Stream, Closed, Error or Pong.
+type Event interface {
+	isEvent()
+}
+
+// Opened is the first event of every session, sent once the handshake is
+// done. It reports the subprotocol and extensions the server agreed to.
+type Opened struct {
+	Subprotocol string
+	Extensions  []string
+}
+
+// Message is a data message received from the server.
+type Message struct {
+	Type int
+	Data []byte
 }
+
+// Stream is a message larger than DialOptions.StreamThreshold. Its body is
+// read straight from the connection, so no further event is delivered until
+// the consumer has read Body to EOF or closed it.
+type Stream struct {
+	Type int
+	Body io.ReadCloser
+}
+
+// Closed is the final event of a session that ended with a closing handshake.
+// Local is true when the close was initiated by Session.Close.
+type Closed struct {
+	Code   int
+	Reason string
+	Local  bool
+}
+
+// Error is the final event of a session that ended without a closing
+// handshake, for example because the network connection dropped.
+type Error struct {
+	Err error
+}
+
+// Pong reports the round-trip time of a keep-alive ping.
+type Pong struct {
+	RTT time.Duration
+}
+
+func (Opened) isEvent()  {}
+func (Message) isEvent() {}
+func (Stream) isEvent()  {}
+func (Closed) isEvent()  {}
+func (Error) isEvent()   {}
+func (Pong) isEvent()    {}
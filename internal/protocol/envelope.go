@@ -0,0 +1,95 @@
+// Package protocol defines the event envelope that the RavenPair server wraps
+// every WebSocket message in, and how known events are shown to users.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Event types with a well-known payload.
+const (
+	TypeJoin       = "join"
+	TypeLeave      = "leave"
+	TypeChat       = "chat"
+	TypeCursor     = "cursor"
+	TypeFileChange = "file_change"
+)
+
+// Envelope wraps every event exchanged in a pair session.
+type Envelope struct {
+	// Type names the event and decides the shape of Payload.
+	Type string `json:"type"`
+	// PairID identifies the pair session the event belongs to.
+	PairID string `json:"pair_id"`
+	// Sender is the user who caused the event; empty for server events.
+	Sender string `json:"sender,omitempty"`
+	// Seq orders the events of a pair session.
+	Seq uint64 `json:"seq,omitempty"`
+	// Timestamp is when the server accepted the event.
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	// Payload holds the type-specific data, still encoded.
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// JoinPayload is the payload of a join event.
+type JoinPayload struct {
+	Name string `json:"name,omitempty"`
+}
+
+// LeavePayload is the payload of a leave event.
+type LeavePayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// ChatPayload is the payload of a chat event.
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+// CursorPayload is the payload of a cursor event. Lines and columns count
+// from one.
+type CursorPayload struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column,omitempty"`
+}
+
+// FileChangePayload is the payload of a file_change event. Action is one of
+// created, modified, deleted or renamed; OldPath is set for renames.
+type FileChangePayload struct {
+	Path    string `json:"path"`
+	Action  string `json:"action"`
+	OldPath string `json:"old_path,omitempty"`
+}
+
+// ErrNotEnvelope is returned by Decode when a message is not an event
+// envelope.
+var ErrNotEnvelope = errors.New("not a RavenPair event envelope")
+
+// Decode parses a JSON message into an Envelope. It only checks that the
+// message is an object with a type; use Validate for a full check.
+func Decode(data []byte) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrNotEnvelope, err)
+	}
+	if e.Type == "" {
+		return Envelope{}, fmt.Errorf("%w: missing type", ErrNotEnvelope)
+	}
+	return e, nil
+}
+
+// DecodePayload decodes the payload of e into v. An absent payload leaves v
+// unchanged.
+func (e Envelope) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 || string(e.Payload) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("%s payload: %w", e.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+// Format renders a known event as a single human-friendly line, prefixed
+// with its local time of day. It returns false for unknown event types,
+// terminal input and output, and payloads that do not match their type, which
+// callers should print raw.
+func Format(e Envelope) (string, bool) {
+	var text string
+	switch e.Type {
+	case TypeJoin:
+		var p JoinPayload
+		if e.DecodePayload(&p) != nil {
+			return "", false
+		}
+		text = fmt.Sprintf("%s joined", who(e.Sender, p.Name))
+	case TypeLeave:
+		var p LeavePayload
+		if e.DecodePayload(&p) != nil {
+			return "", false
+		}
+		text = fmt.Sprintf("%s left", who(e.Sender, ""))
+		if p.Reason != "" {
+			text += fmt.Sprintf(" (%s)", p.Reason)
+		}
+	case TypeChat:
+		var p ChatPayload
+		if e.DecodePayload(&p) != nil {
+			return "", false
+		}
+		text = fmt.Sprintf("%s: %s", who(e.Sender, ""), p.Text)
+	case TypeCursor:
+		var p CursorPayload
+		if e.DecodePayload(&p) != nil || p.Path == "" {
+			return "", false
+		}
+		pos := fmt.Sprintf("%s:%d", p.Path, p.Line)
+		if p.Column > 0 {
+			pos += fmt.Sprintf(":%d", p.Column)
+		}
+		text = fmt.Sprintf("%s is at %s", who(e.Sender, ""), pos)
+	case TypeFileChange:
+		var p FileChangePayload
+		if e.DecodePayload(&p) != nil || p.Path == "" || p.Action == "" {
+			return "", false
+		}
+		if p.Action == "renamed" && p.OldPath != "" {
+			text = fmt.Sprintf("%s renamed %s to %s", who(e.Sender, ""), p.OldPath, p.Path)
+		} else {
+			text = fmt.Sprintf("%s %s %s", who(e.Sender, ""), p.Action, p.Path)
+		}
+	case TypeTermStart, TypeTermResize:
+		var p TermSizePayload
+		if e.DecodePayload(&p) != nil || p.Cols == 0 || p.Rows == 0 {
+			return "", false
+		}
+		if e.Type == TypeTermStart {
+			text = fmt.Sprintf("%s started sharing a %dx%d terminal", who(e.Sender, ""), p.Cols, p.Rows)
+		} else {
+			text = fmt.Sprintf("%s resized the terminal to %dx%d", who(e.Sender, ""), p.Cols, p.Rows)
+		}
+	case TypeTermRole:
+		var p TermRolePayload
+		if e.DecodePayload(&p) != nil || p.User == "" || !ValidRole(p.Role) {
+			return "", false
+		}
+		text = fmt.Sprintf("%s made %s a %s", who(e.Sender, ""), p.User, p.Role)
+	case TypeTermEnd:
+		var p TermEndPayload
+		if e.DecodePayload(&p) != nil {
+			return "", false
+		}
+		text = fmt.Sprintf("%s stopped sharing the terminal (exit status %d)", who(e.Sender, ""), p.ExitCode)
+	case TypeGitShare:
+		var p GitSharePayload
+		if e.DecodePayload(&p) != nil || p.Head == "" {
+			return "", false
+		}
+		text = fmt.Sprintf("%s is on %s at %s", who(e.Sender, ""), p.Branch, ShortHash(p.Head))
+		if p.Subject != "" {
+			text += fmt.Sprintf(" (%s)", p.Subject)
+		}
+		if p.Remote != "" {
+			text += " from " + p.Remote
+		}
+		if p.Files > 0 {
+			text += fmt.Sprintf("; uncommitted changes to %d file(s), +%d -%d: ravenpair git apply --from %s %s", p.Files, p.Insertions, p.Deletions, e.Sender, p.ID)
+		}
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("[%s] %s", clock(e.Timestamp), text), true
+}
+
+// who names the sender of an event, preferring a display name when given.
+func who(sender, name string) string {
+	switch {
+	case name != "" && sender != "" && name != sender:
+		return fmt.Sprintf("%s (%s)", name, sender)
+	case name != "":
+		return name
+	case sender != "":
+		return sender
+	}
+	return "someone"
+}
+
+func clock(t time.Time) string {
+	if t.IsZero() {
+		return "--:--:--"
+	}
+	return t.Local().Format("15:04:05")
+}
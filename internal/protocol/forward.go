@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"encoding/binary"
+)
+
+// Event types of port forwarding. A participant asks for a tunnel with
+// forward.request, and the one that runs "forward --serve" answers with
+// forward.reply; either side ends a tunnel with forward.cancel. Each TCP
+// connection through a tunnel is a channel: the listening side opens it with
+// forward.open and each side sends forward.close once it has nothing more to
+// send. The data itself travels in binary frames; see ForwardFrame. Each side
+// acknowledges the frames it wrote to its connection with forward.ack, and
+// sends only a window of frames ahead of those acknowledgements.
+const (
+	TypeForwardRequest = "forward.request"
+	TypeForwardReply   = "forward.reply"
+	TypeForwardCancel  = "forward.cancel"
+	TypeForwardOpen    = "forward.open"
+	TypeForwardClose   = "forward.close"
+	TypeForwardAck     = "forward.ack"
+)
+
+// ForwardRequestPayload asks for a tunnel. Without Reverse, the requester
+// listens on Listen and the server connects to Target; with Reverse, the
+// server listens on Listen and the requester connects to Target. Node
+// identifies the requester.
+type ForwardRequestPayload struct {
+	Tunnel  uint32 `json:"tunnel"`
+	Node    uint32 `json:"node"`
+	Reverse bool   `json:"reverse,omitempty"`
+	Listen  string `json:"listen"`
+	Target  string `json:"target"`
+}
+
+// ForwardReplyPayload answers a forward.request. Node identifies the server
+// that answered, since several may run in a pair session.
+type ForwardReplyPayload struct {
+	Tunnel   uint32 `json:"tunnel"`
+	Node     uint32 `json:"node"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ForwardCancelPayload ends a tunnel on one server, or on all of them when
+// Node is zero.
+type ForwardCancelPayload struct {
+	Tunnel uint32 `json:"tunnel"`
+	Node   uint32 `json:"node,omitempty"`
+}
+
+// ForwardChannelPayload is the payload of forward.open and forward.close
+// events, sent by Node. A forward.close with an Error aborts the channel in
+// both directions.
+type ForwardChannelPayload struct {
+	Node    uint32 `json:"node"`
+	Tunnel  uint32 `json:"tunnel"`
+	Channel uint32 `json:"channel"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ForwardAckPayload tells the partner that Node wrote Frames of the frames
+// it sent on a channel to its connection, counting from the first.
+type ForwardAckPayload struct {
+	Node    uint32 `json:"node"`
+	Tunnel  uint32 `json:"tunnel"`
+	Channel uint32 `json:"channel"`
+	Frames  uint64 `json:"frames"`
+}
+
+// Binary frames start with a byte naming their kind.
+const frameForward = 'F'
+
+// forwardHeaderSize is the size of the header of a forward frame: its kind,
+// node, tunnel and channel.
+const forwardHeaderSize = 1 + 4 + 4 + 4
+
+// ForwardFrame carries data on a channel. Node identifies the sender, so
+// that it can ignore its own frames should the server echo them.
+type ForwardFrame struct {
+	Node, Tunnel, Channel uint32
+	Data                  []byte
+}
+
+// EncodeForwardFrame builds the binary message carrying f.
+func EncodeForwardFrame(f ForwardFrame) []byte {
+	b := make([]byte, forwardHeaderSize, forwardHeaderSize+len(f.Data))
+	b[0] = frameForward
+	binary.BigEndian.PutUint32(b[1:], f.Node)
+	binary.BigEndian.PutUint32(b[5:], f.Tunnel)
+	binary.BigEndian.PutUint32(b[9:], f.Channel)
+	return append(b, f.Data...)
+}
+
+// DecodeForwardFrame parses a binary message built by EncodeForwardFrame. It
+// returns false for other messages. The frame's Data shares b.
+func DecodeForwardFrame(b []byte) (ForwardFrame, bool) {
+	if len(b) < forwardHeaderSize || b[0] != frameForward {
+		return ForwardFrame{}, false
+	}
+	return ForwardFrame{
+		Node:    binary.BigEndian.Uint32(b[1:]),
+		Tunnel:  binary.BigEndian.Uint32(b[5:]),
+		Channel: binary.BigEndian.Uint32(b[9:]),
+		Data:    b[forwardHeaderSize:],
+	}, true
+}
@@ -0,0 +1,49 @@
+package protocol
+
+// Event types of git-aware pairing. A participant publishes where its
+// working tree is with git.share; when it shares its uncommitted changes
+// too, others fetch them with git.request and get them back in git.patch.
+const (
+	TypeGitShare   = "git.share"
+	TypeGitRequest = "git.request"
+	TypeGitPatch   = "git.patch"
+)
+
+// GitSharePayload describes a working tree. ID names the event for
+// "git apply". Files, Insertions and Deletions summarize the patch of its
+// uncommitted changes; Files is zero when no patch was shared.
+type GitSharePayload struct {
+	ID         string `json:"id"`
+	Branch     string `json:"branch"`
+	Head       string `json:"head"`
+	Subject    string `json:"subject,omitempty"`
+	Remote     string `json:"remote,omitempty"`
+	Files      int    `json:"files,omitempty"`
+	Insertions int    `json:"insertions,omitempty"`
+	Deletions  int    `json:"deletions,omitempty"`
+}
+
+// GitRequestPayload asks for the patch of the git.share event ID on behalf
+// of Node.
+type GitRequestPayload struct {
+	ID   string `json:"id"`
+	Node uint32 `json:"node"`
+}
+
+// GitPatchPayload answers a git.request of the participant To. Patch is the
+// output of "git diff --binary" against Head.
+type GitPatchPayload struct {
+	ID     string `json:"id"`
+	To     uint32 `json:"to"`
+	Branch string `json:"branch"`
+	Head   string `json:"head"`
+	Patch  string `json:"patch"`
+}
+
+// ShortHash abbreviates a commit hash as git does by default.
+func ShortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
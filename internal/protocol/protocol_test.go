@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	e, err := Decode([]byte(`{"type":"chat","pair_id":"p1","sender":"ana","seq":7,"timestamp":"2026-01-02T15:04:05Z","payload":{"text":"hi"}}`))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if e.Type != TypeChat || e.PairID != "p1" || e.Sender != "ana" || e.Seq != 7 || e.Timestamp.Year() != 2026 {
+		t.Errorf("unexpected envelope: %+v", e)
+	}
+	var p ChatPayload
+	if err := e.DecodePayload(&p); err != nil || p.Text != "hi" {
+		t.Errorf("unexpected payload %+v: %v", p, err)
+	}
+
+	for _, bad := range []string{`not json`, `{"seq":1}`, `[1,2]`} {
+		if _, err := Decode([]byte(bad)); !errors.Is(err, ErrNotEnvelope) {
+			t.Errorf("Decode(%s): expected ErrNotEnvelope, got %v", bad, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := map[string]string{
+		`{"type":"join","sender":"ana","payload":{"name":"Ana"}}`:                                                                                      "Ana (ana) joined",
+		`{"type":"leave","sender":"bo","payload":{"reason":"timeout"}}`:                                                                                "bo left (timeout)",
+		`{"type":"chat","sender":"ana","payload":{"text":"hi"}}`:                                                                                       "ana: hi",
+		`{"type":"cursor","sender":"bo","payload":{"path":"main.go","line":12,"column":4}}`:                                                            "bo is at main.go:12:4",
+		`{"type":"file_change","sender":"ana","payload":{"path":"a.go","action":"modified"}}`:                                                          "ana modified a.go",
+		`{"type":"file_change","payload":{"path":"b.go","old_path":"a.go","action":"renamed"}}`:                                                        "someone renamed a.go to b.go",
+		`{"type":"term.start","sender":"ana","payload":{"cols":80,"rows":24}}`:                                                                         "ana started sharing a 80x24 terminal",
+		`{"type":"term.role","sender":"ana","payload":{"user":"bo","role":"driver"}}`:                                                                  "ana made bo a driver",
+		`{"type":"term.end","sender":"ana","payload":{"exit_code":1}}`:                                                                                 "ana stopped sharing the terminal (exit status 1)",
+		`{"type":"git.share","sender":"bo","payload":{"id":"5f2e9a1c","branch":"main","head":"1a2b3c4d5e6f","subject":"Fix login"}}`:                   "bo is on main at 1a2b3c4 (Fix login)",
+		`{"type":"git.share","sender":"bo","payload":{"id":"5f2e9a1c","branch":"main","head":"1a2b3c4d5e6f","files":2,"insertions":10,"deletions":1}}`: "bo is on main at 1a2b3c4; uncommitted changes to 2 file(s), +10 -1: ravenpair git apply --from bo 5f2e9a1c",
+	}
+	for in, want := range cases {
+		e, err := Decode([]byte(in))
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", in, err)
+		}
+		got, ok := Format(e)
+		if !ok || !strings.HasSuffix(got, "] "+want) {
+			t.Errorf("Format(%s) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+
+	for _, in := range []string{`{"type":"presence"}`, `{"type":"chat","payload":"oops"}`, `{"type":"term.output","payload":{"data":"aGk="}}`} {
+		e, _ := Decode([]byte(in))
+		if _, ok := Format(e); ok {
+			t.Errorf("expected Format(%s) to fall back to raw", in)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data, err := Encode(TypeTermOutput, "p1", TermDataPayload{Data: []byte{0xff, '\n'}})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if want := `{"type":"term.output","pair_id":"p1","payload":{"data":"/wo="}}`; string(data) != want {
+		t.Errorf("Encode = %s, want %s", data, want)
+	}
+	e, _ := Decode(data)
+	var p TermDataPayload
+	if err := e.DecodePayload(&p); err != nil || string(p.Data) != "\xff\n" {
+		t.Errorf("unexpected payload %q: %v", p.Data, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := `{"type":"cursor","pair_id":"p1","sender":"ana","seq":3,"timestamp":"2026-01-02T15:04:05Z","payload":{"path":"a.go","line":1}}`
+	if err := Validate([]byte(valid)); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	output := `{"type":"term.output","pair_id":"p1","sender":"ana","seq":5,"timestamp":"2026-01-02T15:04:05Z","payload":{"data":"/wo="}}`
+	if err := Validate([]byte(output)); err != nil {
+		t.Errorf("Validate(term.output): %v", err)
+	}
+	unknown := `{"type":"presence","pair_id":"p1","sender":"","seq":4,"timestamp":"2026-01-02T15:04:05Z","payload":[1]}`
+	if err := Validate([]byte(unknown)); err != nil {
+		t.Errorf("unknown types with any payload should validate: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"type":"chat","pair_id":"p1","sender":"ana","seq":1,"timestamp":"2026-01-02T15:04:05Z"}`,
+		`{"type":"chat","pair_id":"p1","sender":"ana","seq":-1,"timestamp":"2026-01-02T15:04:05Z","payload":{"text":"x"}}`,
+		`{"type":"chat","pair_id":"p1","sender":"ana","seq":1,"timestamp":"yesterday","payload":{"text":"x"}}`,
+		`{"type":"file_change","pair_id":"p1","sender":"ana","seq":1,"timestamp":"2026-01-02T15:04:05Z","payload":{"path":"a","action":"moved"}}`,
+		`{"type":"term.role","pair_id":"p1","sender":"ana","seq":1,"timestamp":"2026-01-02T15:04:05Z","payload":{"user":"bo","role":"owner"}}`,
+		`{"type":"term.resize","pair_id":"p1","sender":"ana","seq":1,"timestamp":"2026-01-02T15:04:05Z","payload":{"cols":80}}`,
+		`plain text`,
+	} {
+		if err := Validate([]byte(bad)); !errors.Is(err, ErrNotEnvelope) {
+			t.Errorf("Validate(%s): expected ErrNotEnvelope, got %v", bad, err)
+		}
+	}
+}
+
+func TestValidatePayloads(t *testing.T) {
+	cases := []struct {
+		typ, payload string
+		valid        bool
+	}{
+		{"forward.request", `{"tunnel":1,"node":2,"listen":"localhost:8080","target":"localhost:3000"}`, true},
+		{"forward.request", `{"tunnel":1,"listen":"localhost:8080"}`, false},
+		{"forward.reply", `{"tunnel":1,"node":2,"accepted":true}`, true},
+		{"forward.reply", `{"tunnel":1,"node":2,"accepted":"yes"}`, false},
+		{"forward.cancel", `{"tunnel":1}`, true},
+		{"forward.cancel", `{"tunnel":-1}`, false},
+		{"forward.open", `{"node":2,"tunnel":1,"channel":3}`, true},
+		{"forward.close", `{"node":2,"tunnel":1,"channel":3,"error":"refused"}`, true},
+		{"forward.close", `{"node":2,"tunnel":1}`, false},
+		{"forward.ack", `{"node":2,"tunnel":1,"channel":3,"frames":32}`, true},
+		{"forward.ack", `{"node":2,"tunnel":1,"channel":3}`, false},
+		{"transfer.offer", `{"transfer":1,"name":"a.txt","size":0,"sha256":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855","chunk_size":65536}`, true},
+		{"transfer.offer", `{"transfer":1,"name":"a.txt","size":0,"sha256":"abc","chunk_size":65536}`, false},
+		{"transfer.accept", `{"transfer":1,"node":2,"offset":0}`, true},
+		{"transfer.ack", `{"transfer":1,"node":2,"offset":-1}`, false},
+		{"transfer.reject", `{"transfer":1,"node":2,"reason":"no"}`, true},
+		{"transfer.done", `{"transfer":1,"node":2,"ok":true}`, true},
+		{"transfer.done", `{"transfer":1,"node":2}`, false},
+		{"transfer.cancel", `{"transfer":1}`, true},
+		{"transfer.cancel", `{"reason":"stopped"}`, false},
+		{"sync.manifest", `{"node":2,"files":{"a.go":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}`, true},
+		{"sync.manifest", `{"node":2,"files":{"a.go":"x"}}`, false},
+		{"sync.change", `{"node":2,"path":"a.go","hash":""}`, true},
+		{"sync.change", `{"node":2,"hash":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`, false},
+		{"sync.request", `{"node":2,"from":3,"path":"a.go","hash":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855","signature":"AAAA"}`, true},
+		{"sync.request", `{"node":2,"path":"a.go","hash":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`, false},
+		{"sync.delta", `{"node":2,"to":3,"path":"a.go","hash":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855","delta":null}`, true},
+		{"sync.delta", `{"node":2,"to":3,"path":"a.go","hash":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}`, false},
+		{"git.share", `{"id":"e1","branch":"main","head":"0123abc","files":2,"insertions":5}`, true},
+		{"git.share", `{"id":"e1","branch":"main","head":"0123abc","files":-2}`, false},
+		{"git.request", `{"id":"e1","node":2}`, true},
+		{"git.request", `{"id":"","node":2}`, false},
+		{"git.patch", `{"id":"e1","to":2,"branch":"main","head":"0123abc","patch":"diff --git a/x b/x\n"}`, true},
+		{"git.patch", `{"id":"e1","to":2,"branch":"main","head":"0123abc"}`, false},
+	}
+	for _, c := range cases {
+		msg := `{"type":"` + c.typ + `","pair_id":"p1","sender":"ana","seq":1,"timestamp":"2026-01-02T15:04:05Z","payload":` + c.payload + `}`
+		err := Validate([]byte(msg))
+		if c.valid && err != nil {
+			t.Errorf("Validate(%s): %v", msg, err)
+		}
+		if !c.valid && !errors.Is(err, ErrNotEnvelope) {
+			t.Errorf("Validate(%s): expected ErrNotEnvelope, got %v", msg, err)
+		}
+	}
+}
+
+func TestForwardFrame(t *testing.T) {
+	b := EncodeForwardFrame(ForwardFrame{Node: 1, Tunnel: 2, Channel: 3, Data: []byte("data")})
+	f, ok := DecodeForwardFrame(b)
+	if !ok || f.Node != 1 || f.Tunnel != 2 || f.Channel != 3 || string(f.Data) != "data" {
+		t.Errorf("DecodeForwardFrame = %+v, %v", f, ok)
+	}
+	if _, ok := DecodeForwardFrame([]byte("Fshort")); ok {
+		t.Error("expected a short frame to be rejected")
+	}
+}
+
+func TestTransferFrame(t *testing.T) {
+	b := EncodeTransferFrame(TransferFrame{Transfer: 7, Offset: 1 << 33, Data: []byte("chunk")})
+	f, ok := DecodeTransferFrame(b)
+	if !ok || f.Transfer != 7 || f.Offset != 1<<33 || string(f.Data) != "chunk" {
+		t.Errorf("DecodeTransferFrame = %+v, %v", f, ok)
+	}
+	if _, ok := DecodeTransferFrame(EncodeForwardFrame(ForwardFrame{Data: []byte("data")})); ok {
+		t.Error("expected a forward frame to be rejected")
+	}
+}
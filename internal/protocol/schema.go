@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed envelope.schema.json
+var envelopeSchemaJSON []byte
+
+const envelopeSchemaURL = "https://ravenpair.dev/schema/envelope.json"
+
+var envelopeSchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	if err := c.AddResource(envelopeSchemaURL, bytes.NewReader(envelopeSchemaJSON)); err != nil {
+		return nil, err
+	}
+	return c.Compile(envelopeSchemaURL)
+})
+
+// Validate checks a JSON message against the envelope schema, including the
+// payloads of the known event types.
+func Validate(data []byte) error {
+	schema, err := envelopeSchema()
+	if err != nil {
+		return fmt.Errorf("envelope schema: %w", err)
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotEnvelope, err)
+	}
+	if err := schema.Validate(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotEnvelope, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package protocol
+
+// Event types of directory syncing. Each participant announces the files it
+// has with sync.manifest when it starts, answering the manifests of others
+// with its own, and each change it sees with sync.change. A participant
+// missing a version asks the one that announced it with sync.request,
+// sending the signature of its own copy, and gets back a sync.delta against
+// it. Paths are relative to the synced directory and use slashes; digests
+// are hex SHA-256, empty for a deleted file.
+const (
+	TypeSyncManifest = "sync.manifest"
+	TypeSyncChange   = "sync.change"
+	TypeSyncRequest  = "sync.request"
+	TypeSyncDelta    = "sync.delta"
+)
+
+// SyncManifestPayload lists the files of Node, by path. Reply is set on the
+// manifests sent in answer to another.
+type SyncManifestPayload struct {
+	Node  uint32            `json:"node"`
+	Files map[string]string `json:"files"`
+	Reply bool              `json:"reply,omitempty"`
+}
+
+// SyncChangePayload announces that Node changed Path from the version Base
+// to Hash.
+type SyncChangePayload struct {
+	Node uint32 `json:"node"`
+	Path string `json:"path"`
+	Base string `json:"base,omitempty"`
+	Hash string `json:"hash"`
+}
+
+// SyncRequestPayload asks the participant From for version Hash of Path.
+// Signature describes the requester's own copy, empty when it has none.
+type SyncRequestPayload struct {
+	Node      uint32 `json:"node"`
+	From      uint32 `json:"from"`
+	Path      string `json:"path"`
+	Hash      string `json:"hash"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// SyncDeltaPayload answers a sync.request of the participant To with the
+// delta that turns its copy of Path into version Hash.
+type SyncDeltaPayload struct {
+	Node  uint32 `json:"node"`
+	To    uint32 `json:"to"`
+	Path  string `json:"path"`
+	Hash  string `json:"hash"`
+	Delta []byte `json:"delta"`
+}
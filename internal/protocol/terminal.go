@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event types of a shared terminal. The host sends term.start once its shell
+// runs, term.output for everything the shell prints, term.resize when its
+// terminal changes size, term.role when a participant's role changes and
+// term.end when the shell exits; guests send term.input with their
+// keystrokes.
+const (
+	TypeTermStart  = "term.start"
+	TypeTermOutput = "term.output"
+	TypeTermInput  = "term.input"
+	TypeTermResize = "term.resize"
+	TypeTermRole   = "term.role"
+	TypeTermEnd    = "term.end"
+)
+
+// Roles of the participants of a shared terminal. Only drivers may type;
+// navigators follow along and guide the driver, and spectators watch. The
+// host enforces roles on term.input events.
+const (
+	RoleDriver    = "driver"
+	RoleNavigator = "navigator"
+	RoleSpectator = "spectator"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	return role == RoleDriver || role == RoleNavigator || role == RoleSpectator
+}
+
+// TermSizePayload is the payload of term.start and term.resize events.
+type TermSizePayload struct {
+	Cols  uint16 `json:"cols"`
+	Rows  uint16 `json:"rows"`
+	Shell string `json:"shell,omitempty"`
+}
+
+// TermDataPayload is the payload of term.output and term.input events. Data
+// is base64 in JSON, since terminal output need not be valid UTF-8.
+type TermDataPayload struct {
+	Data []byte `json:"data"`
+}
+
+// TermRolePayload is the payload of a term.role event, which tells everyone
+// the role User now has.
+type TermRolePayload struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// TermEndPayload is the payload of a term.end event.
+type TermEndPayload struct {
+	ExitCode int `json:"exit_code"`
+}
+
+// Encode builds the message for an event of the given type sent by this
+// client. The server fills in the sender, sequence number and timestamp.
+func Encode(eventType, pairID string, payload interface{}) ([]byte, error) {
+	e := Envelope{Type: eventType, PairID: pairID}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s payload: %w", eventType, err)
+		}
+		e.Payload = raw
+	}
+	return json.Marshal(e)
+}
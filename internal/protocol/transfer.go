@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"encoding/binary"
+)
+
+// Event types of file transfers. The sender offers a file with
+// transfer.offer and a receiver answers with transfer.accept, giving the
+// offset to start from, or transfer.reject. The receiver acknowledges each
+// chunk it wrote with transfer.ack and ends with transfer.done once it has
+// verified the whole file; either side gives up with transfer.cancel. The
+// chunks travel in binary frames; see TransferFrame.
+const (
+	TypeTransferOffer  = "transfer.offer"
+	TypeTransferAccept = "transfer.accept"
+	TypeTransferReject = "transfer.reject"
+	TypeTransferAck    = "transfer.ack"
+	TypeTransferDone   = "transfer.done"
+	TypeTransferCancel = "transfer.cancel"
+)
+
+// TransferOfferPayload offers a file. SHA256 is the hex digest of the whole
+// file, which also tells a receiver whether a partial copy it kept from an
+// interrupted transfer belongs to the same file.
+type TransferOfferPayload struct {
+	Transfer  uint32 `json:"transfer"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// TransferAcceptPayload accepts an offer on behalf of the receiver Node.
+// Offset is where the sender must start: zero, or the end of the last
+// chunk acknowledged before an interruption.
+type TransferAcceptPayload struct {
+	Transfer uint32 `json:"transfer"`
+	Node     uint32 `json:"node"`
+	Offset   int64  `json:"offset"`
+}
+
+// TransferRejectPayload declines an offer.
+type TransferRejectPayload struct {
+	Transfer uint32 `json:"transfer"`
+	Node     uint32 `json:"node"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// TransferAckPayload tells the sender that the receiver Node wrote the file
+// up to Offset.
+type TransferAckPayload struct {
+	Transfer uint32 `json:"transfer"`
+	Node     uint32 `json:"node"`
+	Offset   int64  `json:"offset"`
+}
+
+// TransferDonePayload ends a transfer on the receiver Node. OK is set when
+// the file matched its digest and was saved; otherwise Error says why not.
+type TransferDonePayload struct {
+	Transfer uint32 `json:"transfer"`
+	Node     uint32 `json:"node"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TransferCancelPayload abandons a transfer. From the sender, Node is the
+// receiver it addresses, or zero for every receiver; from a receiver, Node
+// is the receiver itself.
+type TransferCancelPayload struct {
+	Transfer uint32 `json:"transfer"`
+	Node     uint32 `json:"node,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+const frameTransfer = 'T'
+
+// transferHeaderSize is the size of the header of a transfer frame: its
+// kind, transfer and offset.
+const transferHeaderSize = 1 + 4 + 8
+
+// TransferFrame carries the chunk of a file starting at Offset.
+type TransferFrame struct {
+	Transfer uint32
+	Offset   int64
+	Data     []byte
+}
+
+// EncodeTransferFrame builds the binary message carrying f.
+func EncodeTransferFrame(f TransferFrame) []byte {
+	b := make([]byte, transferHeaderSize, transferHeaderSize+len(f.Data))
+	b[0] = frameTransfer
+	binary.BigEndian.PutUint32(b[1:], f.Transfer)
+	binary.BigEndian.PutUint64(b[5:], uint64(f.Offset))
+	return append(b, f.Data...)
+}
+
+// DecodeTransferFrame parses a binary message built by EncodeTransferFrame.
+// It returns false for other messages. The frame's Data shares b.
+func DecodeTransferFrame(b []byte) (TransferFrame, bool) {
+	if len(b) < transferHeaderSize || b[0] != frameTransfer {
+		return TransferFrame{}, false
+	}
+	offset := binary.BigEndian.Uint64(b[5:])
+	if offset > 1<<62 {
+		return TransferFrame{}, false
+	}
+	return TransferFrame{
+		Transfer: binary.BigEndian.Uint32(b[1:]),
+		Offset:   int64(offset),
+		Data:     b[transferHeaderSize:],
+	}, true
+}
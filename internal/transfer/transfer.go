@@ -0,0 +1,224 @@
+// Package transfer moves files over a pair session in chunks: it keeps what
+// is received in a partial file that survives interruptions, and saves it
+// under its name only once its SHA-256 digest is verified.
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is the size of the chunks a file is sent in.
+const DefaultChunkSize = 64 * 1024
+
+// ErrChecksum reports a received file that does not match its digest.
+var ErrChecksum = errors.New("the file does not match its SHA-256 digest")
+
+// HashFile returns the hex SHA-256 digest of the file at path.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SafeName checks that name, as offered by a sender, is a plain file name
+// that stays in the directory it is saved to.
+func SafeName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`+"\x00") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+// Incoming is a file being received. Its data goes to a hidden partial file
+// next to Path, named after the file's digest, so that an interrupted
+// transfer of the same file can resume where it stopped.
+type Incoming struct {
+	// Path is where the file is saved once complete.
+	Path   string
+	part   string
+	size   int64
+	sum    string
+	f      *os.File
+	offset int64
+}
+
+// NewIncoming prepares to receive a file of the given size and digest as
+// dir/name. When a partial file of an earlier transfer of the same file
+// exists, Offset is the end of its last whole chunk; nothing is written
+// until Start.
+func NewIncoming(dir, name string, size int64, sum string, chunkSize int) (*Incoming, error) {
+	if err := SafeName(name); err != nil {
+		return nil, err
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 digest %q", sum)
+	}
+	if size < 0 || chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid size %d or chunk size %d", size, chunkSize)
+	}
+	in := &Incoming{
+		Path: filepath.Join(dir, name),
+		part: filepath.Join(dir, fmt.Sprintf(".%s.%s.part", name, sum[:12])),
+		size: size,
+		sum:  strings.ToLower(sum),
+	}
+	// A chunk may have been cut short by the interruption; only whole chunks
+	// count as received.
+	if fi, err := os.Stat(in.part); err == nil && fi.Mode().IsRegular() && fi.Size() <= size {
+		in.offset = fi.Size()
+		if in.offset < size {
+			in.offset -= in.offset % int64(chunkSize)
+		}
+	}
+	return in, nil
+}
+
+// Offset is how much of the file was received.
+func (in *Incoming) Offset() int64 { return in.offset }
+
+// Size is the size of the file.
+func (in *Incoming) Size() int64 { return in.size }
+
+// Complete reports whether the whole file was received.
+func (in *Incoming) Complete() bool { return in.offset == in.size }
+
+// Start opens the partial file, dropping anything past Offset.
+func (in *Incoming) Start() error {
+	f, err := os.OpenFile(in.part, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(in.offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(in.offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	in.f = f
+	return nil
+}
+
+// Write appends the chunk that starts at Offset.
+func (in *Incoming) Write(data []byte) error {
+	if in.f == nil {
+		return errors.New("the transfer has not started")
+	}
+	if int64(len(data)) > in.size-in.offset {
+		return errors.New("more data than the size of the file")
+	}
+	n, err := in.f.Write(data)
+	in.offset += int64(n)
+	return err
+}
+
+// Finish verifies the received file and moves it to Path, replacing any
+// file there. A file that does not match its digest is deleted, and
+// ErrChecksum returned.
+func (in *Incoming) Finish() error {
+	if in.f == nil {
+		return errors.New("the transfer has not started")
+	}
+	f := in.f
+	in.f = nil
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != in.sum {
+		f.Close()
+		os.Remove(in.part)
+		return ErrChecksum
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(in.part, in.Path); err != nil {
+		return err
+	}
+	// Make the rename durable too, where directories can be synced.
+	if d, err := os.Open(filepath.Dir(in.Path)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Abort stops receiving, keeping what was received for a later transfer to
+// resume from, unless that is nothing.
+func (in *Incoming) Abort() {
+	if in.f == nil {
+		return
+	}
+	in.f.Close()
+	in.f = nil
+	if in.offset == 0 {
+		os.Remove(in.part)
+	}
+}
+
+// Limiter paces a transfer to a rate in bytes per second. A nil Limiter
+// does not limit.
+type Limiter struct {
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+// NewLimiter returns a Limiter for rate bytes per second, or nil when rate
+// is not positive.
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: rate}
+}
+
+// Wait waits until the n bytes about to be sent keep the transfer within
+// its rate since the first call.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	due := l.start.Add(time.Duration(float64(l.sent) / float64(l.rate) * float64(time.Second)))
+	l.sent += int64(n)
+	d := time.Until(due)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,141 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := HashFile(path)
+	if err != nil || sum != digest("hello") {
+		t.Errorf("HashFile = %q, %v", sum, err)
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for _, bad := range []string{"", ".", "..", "../x", "a/b", `a\b`} {
+		if SafeName(bad) == nil {
+			t.Errorf("SafeName(%q): expected an error", bad)
+		}
+	}
+	if err := SafeName("report.pdf"); err != nil {
+		t.Errorf("SafeName: %v", err)
+	}
+}
+
+func TestIncoming(t *testing.T) {
+	dir := t.TempDir()
+	data := "hello, world"
+	in, err := NewIncoming(dir, "greeting.txt", int64(len(data)), digest(data), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Offset() != 0 {
+		t.Fatalf("Offset = %d, want 0", in.Offset())
+	}
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Interrupted in the middle of the second chunk.
+	if err := in.Write([]byte("hello,")); err != nil {
+		t.Fatal(err)
+	}
+	in.Abort()
+	if _, err := os.Stat(in.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected no file before the transfer completes, got %v", err)
+	}
+
+	in, err = NewIncoming(dir, "greeting.txt", int64(len(data)), digest(data), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Offset() != 4 {
+		t.Fatalf("Offset after resume = %d, want 4", in.Offset())
+	}
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Write([]byte(data[4:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Write([]byte("!")); err == nil {
+		t.Error("expected an error writing past the size of the file")
+	}
+	if !in.Complete() {
+		t.Fatal("expected the transfer to be complete")
+	}
+	if err := in.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "greeting.txt"))
+	if err != nil || string(got) != data {
+		t.Errorf("saved file = %q, %v", got, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the saved file to remain, got %d entries", len(entries))
+	}
+}
+
+func TestIncomingChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	in, err := NewIncoming(dir, "f", 5, digest("hello"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Write([]byte("jello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Finish(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Finish = %v, want ErrChecksum", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the partial file to be deleted, got %d entries", len(entries))
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var none *Limiter
+	if err := none.Wait(context.Background(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background(), 50); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The third chunk is due once the first 100 bytes took 100ms.
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("3 chunks of 50 bytes at 1000 B/s took %v", d)
+	}
+
+	slow := NewLimiter(1)
+	if err := slow.Wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := slow.Wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait after cancel = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// UnixScheme is the URL scheme used to address a server listening on a Unix
+// domain socket, as in unix:///run/ravenpair.sock.
+const UnixScheme = "unix://"
+
+// UnixSocketHost is the placeholder host used in request URLs when the
+// connection actually goes over a Unix domain socket.
+const UnixSocketHost = "localhost"
+
+// DialFunc matches the dial hooks of http.Transport and websocket.Dialer.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// SocketPath returns the socket path of a unix:// server address and whether
+// serverURL uses that scheme.
+func SocketPath(serverURL string) (string, bool) {
+	if !strings.HasPrefix(serverURL, UnixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(serverURL, UnixScheme), true
+}
+
+// UnixDialer returns a DialFunc that ignores the requested address and
+// connects to the Unix domain socket at path instead.
+func UnixDialer(path string) DialFunc {
+	var d net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// ParseResolve parses curl-style "host:port:addr" overrides into a map from
+// host:port to the address to dial instead. IPv6 addresses may be given in
+// brackets.
+func ParseResolve(entries []string) (map[string]string, error) {
+	overrides := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid --resolve %q: expected host:port:addr", entry)
+		}
+		host, port := parts[0], parts[1]
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		overrides[net.JoinHostPort(host, port)] = net.JoinHostPort(addr, port)
+	}
+	return overrides, nil
+}
+
+// ResolveDialer returns a DialFunc that connects to the overridden address for
+// any host:port listed in overrides and dials everything else unchanged.
+func ResolveDialer(overrides map[string]string) DialFunc {
+	var d net.Dialer
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if target, ok := overrides[addr]; ok {
+			addr = target
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
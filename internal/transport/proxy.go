@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ProxyFunc returns the proxy selection used by both adapters. When proxyURL
+// is empty the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// decide; otherwise every connection goes through proxyURL, which must be an
+// http:// or socks5:// URL and may carry user:password credentials.
+func ProxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q: use http:// or socks5://", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+	return http.ProxyURL(u), nil
+}
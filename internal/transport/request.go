@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// JoinPath appends p, which may carry its own query string, to the path of
+// base. Servers mounted under a path prefix such as
+// https://tools.corp/ravenpair/ are therefore addressed correctly, and any
+// query on base is kept.
+func JoinPath(base, p string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parsing server URL: %w", err)
+	}
+	ref, err := url.Parse(p)
+	if err != nil {
+		return "", fmt.Errorf("parsing path %q: %w", p, err)
+	}
+
+	// Join the escaped forms so that escaped slashes, as in pair IDs, survive.
+	joined := strings.TrimRight(u.EscapedPath(), "/") + "/" + strings.TrimLeft(ref.EscapedPath(), "/")
+	if u.Path, err = url.PathUnescape(joined); err != nil {
+		return "", fmt.Errorf("joining path %q: %w", p, err)
+	}
+	u.RawPath = joined
+	switch {
+	case u.RawQuery == "":
+		u.RawQuery = ref.RawQuery
+	case ref.RawQuery != "":
+		u.RawQuery += "&" + ref.RawQuery
+	}
+	return u.String(), nil
+}
+
+// ParseHeaders parses curl-style "Name: value" lines into a header set. A
+// Host header overrides the Host of each request rather than being sent as is.
+func ParseHeaders(lines []string) (http.Header, error) {
+	h := http.Header{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q: expected 'Name: value'", line)
+		}
+		h.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value))
+	}
+	return h, nil
+}
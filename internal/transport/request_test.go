@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"testing"
+)
+
+func TestJoinPath(t *testing.T) {
+	cases := []struct {
+		base, path, want string
+	}{
+		{"http://localhost:8080", "/api/status", "http://localhost:8080/api/status"},
+		{"https://tools.corp/ravenpair/", "/api/status", "https://tools.corp/ravenpair/api/status"},
+		{"wss://tools.corp/ravenpair", "ws", "wss://tools.corp/ravenpair/ws"},
+		{"https://tools.corp/ravenpair?tenant=a", "/ws?pair=1", "https://tools.corp/ravenpair/ws?tenant=a&pair=1"},
+		{"https://tools.corp/raven%20pair", "/ws/pairs/a%2Fb", "https://tools.corp/raven%20pair/ws/pairs/a%2Fb"},
+	}
+	for _, tc := range cases {
+		got, err := JoinPath(tc.base, tc.path)
+		if err != nil {
+			t.Fatalf("JoinPath(%q, %q): %v", tc.base, tc.path, err)
+		}
+		if got != tc.want {
+			t.Errorf("JoinPath(%q, %q) = %q, want %q", tc.base, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders([]string{"x-team: ravens", "X-Team: pair", "Host:tools.corp"})
+	if err != nil {
+		t.Fatalf("ParseHeaders: %v", err)
+	}
+	if got := h.Values("X-Team"); len(got) != 2 || got[0] != "ravens" || got[1] != "pair" {
+		t.Errorf("unexpected X-Team values: %v", got)
+	}
+	if h.Get("Host") != "tools.corp" {
+		t.Errorf("unexpected Host: %q", h.Get("Host"))
+	}
+
+	for _, bad := range []string{"no-colon", ": empty", "bad name: x"} {
+		if _, err := ParseHeaders([]string{bad}); err == nil {
+			t.Errorf("expected ParseHeaders(%q) to fail", bad)
+		}
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	got, err := ParseResolve([]string{"tools.corp:443:10.0.0.7", "api.corp:80:[::1]"})
+	if err != nil {
+		t.Fatalf("ParseResolve: %v", err)
+	}
+	if got["tools.corp:443"] != "10.0.0.7:443" || got["api.corp:80"] != "[::1]:80" {
+		t.Errorf("unexpected overrides: %v", got)
+	}
+	if _, err := ParseResolve([]string{"tools.corp:443"}); err == nil {
+		t.Error("expected an error for a missing address")
+	}
+}
@@ -0,0 +1,144 @@
+// Package transport builds the connection settings shared by the HTTP and
+// WebSocket adapters, so that both talk to the server the same way.
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions describes how to verify the server and authenticate the client.
+type TLSOptions struct {
+	// CACertFile is a PEM bundle of CAs trusted in addition to the system roots.
+	CACertFile string
+	// CertFile and KeyFile hold a PEM client certificate and key for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used for SNI and certificate verification.
+	ServerName string
+	// InsecureSkipVerify disables certificate chain and host name verification.
+	InsecureSkipVerify bool
+	// PinSHA256 lists base64 SHA-256 hashes of acceptable subject public key
+	// infos. When set, at least one certificate presented by the server must
+	// match one of them.
+	PinSHA256 []string
+	// TrustOnFirstUse, when set, replaces certificate chain verification. It
+	// is called on every handshake and decides whether the certificate the
+	// server presented is the one recorded for it.
+	TrustOnFirstUse func(tls.ConnectionState) error
+}
+
+func (o TLSOptions) isZero() bool {
+	return o.CACertFile == "" && o.CertFile == "" && o.KeyFile == "" && o.ServerName == "" &&
+		!o.InsecureSkipVerify && len(o.PinSHA256) == 0 && o.TrustOnFirstUse == nil
+}
+
+// NewTLSConfig builds a tls.Config from opts. It returns nil when opts asks for
+// nothing beyond the defaults.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if opts.isZero() {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CACertFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("a client certificate needs both --cert and --key")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	var checks []func(tls.ConnectionState) error
+	if len(opts.PinSHA256) > 0 {
+		pins, err := parsePins(opts.PinSHA256)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, func(cs tls.ConnectionState) error {
+			return verifyPins(cs, pins)
+		})
+	}
+	if opts.TrustOnFirstUse != nil && !opts.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+		checks = append(checks, opts.TrustOnFirstUse)
+	}
+	if len(checks) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, check := range checks {
+				if err := check(cs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// SPKIHash returns the base64 SHA-256 hash of cert's subject public key info,
+// the format accepted by TLSOptions.PinSHA256.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePins decodes pins given as "sha256//<base64>", "sha256/<base64>" or
+// bare base64.
+func parsePins(raw []string) (map[string]bool, error) {
+	pins := make(map[string]bool, len(raw))
+	for _, p := range raw {
+		p = strings.TrimSpace(p)
+		p = strings.TrimPrefix(p, "sha256//")
+		p = strings.TrimPrefix(p, "sha256/")
+		sum, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 pin %q: expected a base64 encoded 32-byte hash", p)
+		}
+		pins[p] = true
+	}
+	return pins, nil
+}
+
+func verifyPins(cs tls.ConnectionState, pins map[string]bool) error {
+	for _, cert := range cs.PeerCertificates {
+		if pins[SPKIHash(cert)] {
+			return nil
+		}
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate to check against the pinned keys")
+	}
+	return fmt.Errorf("server certificate for %s does not match any pinned key (got sha256//%s)",
+		cs.ServerName, SPKIHash(cs.PeerCertificates[0]))
+}
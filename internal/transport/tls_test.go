@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, cfg *tls.Config, url string) error {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestNewTLSConfigDefaultsToNil(t *testing.T) {
+	cfg, err := NewTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatalf("NewTLSConfig: %v", err)
+	}
+	if cfg != nil {
+		t.Errorf("expected nil config, got %+v", cfg)
+	}
+}
+
+func TestNewTLSConfigTrustsCACert(t *testing.T) {
+	srv := newTLSServer(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewTLSConfig(TLSOptions{CACertFile: caFile})
+	if err != nil {
+		t.Fatalf("NewTLSConfig: %v", err)
+	}
+	if err := get(t, cfg, srv.URL); err != nil {
+		t.Errorf("expected request to succeed with custom CA, got: %v", err)
+	}
+}
+
+func TestNewTLSConfigRejectsEmptyCABundle(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(TLSOptions{CACertFile: caFile}); err == nil {
+		t.Error("expected an error for a bundle without certificates")
+	}
+}
+
+func TestNewTLSConfigRequiresCertAndKey(t *testing.T) {
+	if _, err := NewTLSConfig(TLSOptions{CertFile: "client.pem"}); err == nil {
+		t.Error("expected an error when --key is missing")
+	}
+}
+
+func TestNewTLSConfigPinning(t *testing.T) {
+	srv := newTLSServer(t)
+	pin := SPKIHash(srv.Certificate())
+
+	cfg, err := NewTLSConfig(TLSOptions{InsecureSkipVerify: true, PinSHA256: []string{"sha256//" + pin}})
+	if err != nil {
+		t.Fatalf("NewTLSConfig: %v", err)
+	}
+	if err := get(t, cfg, srv.URL); err != nil {
+		t.Errorf("expected matching pin to succeed, got: %v", err)
+	}
+
+	other := strings.Repeat("A", 43) + "="
+	cfg, err = NewTLSConfig(TLSOptions{InsecureSkipVerify: true, PinSHA256: []string{other}})
+	if err != nil {
+		t.Fatalf("NewTLSConfig: %v", err)
+	}
+	if err := get(t, cfg, srv.URL); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("expected pin mismatch error, got: %v", err)
+	}
+}
+
+func TestNewTLSConfigRejectsMalformedPin(t *testing.T) {
+	if _, err := NewTLSConfig(TLSOptions{PinSHA256: []string{"sha256//nope"}}); err == nil {
+		t.Error("expected an error for a malformed pin")
+	}
+}
+
+func TestNewTLSConfigTrustOnFirstUse(t *testing.T) {
+	srv := newTLSServer(t)
+	for _, want := range []error{nil, errors.New("certificate changed")} {
+		calls := 0
+		cfg, err := NewTLSConfig(TLSOptions{TrustOnFirstUse: func(tls.ConnectionState) error {
+			calls++
+			return want
+		}})
+		if err != nil {
+			t.Fatalf("NewTLSConfig: %v", err)
+		}
+		err = get(t, cfg, srv.URL)
+		if (err == nil) != (want == nil) || calls != 1 {
+			t.Errorf("callback returning %v: got %v after %d call(s)", want, err, calls)
+		}
+	}
+}
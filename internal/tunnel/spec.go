@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Spec describes a forward as given on the command line:
+// [L:|R:][bind_address:]port:host:hostport.
+type Spec struct {
+	// Reverse is set for R: forwards, where the partner listens and this
+	// side connects to Target.
+	Reverse bool
+	// Listen is the address the listening side binds.
+	Listen string
+	// Target is the address the other side connects to.
+	Target string
+}
+
+// ParseSpec parses a forward. A spec without an L: or R: prefix is reverse
+// when reverse is set.
+func ParseSpec(s string, reverse bool) (Spec, error) {
+	spec := Spec{Reverse: reverse}
+	rest := s
+	if p, r, ok := strings.Cut(s, ":"); ok && (p == "L" || p == "R") {
+		spec.Reverse, rest = p == "R", r
+	}
+	parts := splitFields(rest)
+	switch len(parts) {
+	case 3:
+		parts = append([]string{"localhost"}, parts...)
+	case 4:
+	default:
+		return Spec{}, fmt.Errorf("invalid forward %q: use [L:|R:][bind_address:]port:host:hostport", s)
+	}
+	bind, port, err := net.SplitHostPort(parts[0] + ":" + parts[1])
+	if err != nil {
+		return Spec{}, fmt.Errorf("invalid forward %q: %v", s, err)
+	}
+	host, hostPort, err := net.SplitHostPort(parts[2] + ":" + parts[3])
+	if err != nil {
+		return Spec{}, fmt.Errorf("invalid forward %q: %v", s, err)
+	}
+	// Listening on port 0 picks a free port.
+	for i, port := range []string{port, hostPort} {
+		if n, err := strconv.Atoi(port); err != nil || n < i || n > 65535 {
+			return Spec{}, fmt.Errorf("invalid forward %q: bad port %q", s, port)
+		}
+	}
+	if host == "" {
+		return Spec{}, fmt.Errorf("invalid forward %q: missing host", s)
+	}
+	spec.Listen = net.JoinHostPort(bind, port)
+	spec.Target = net.JoinHostPort(host, hostPort)
+	return spec, nil
+}
+
+// splitFields splits s at the colons outside square brackets, so that an
+// IPv6 address such as [::1] stays one field.
+func splitFields(s string) []string {
+	var fields []string
+	start, bracketed := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			bracketed = true
+		case ']':
+			bracketed = false
+		case ':':
+			if !bracketed {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+func (s Spec) String() string {
+	if s.Reverse {
+		return fmt.Sprintf("R:%s:%s", s.Listen, s.Target)
+	}
+	return fmt.Sprintf("L:%s:%s", s.Listen, s.Target)
+}
+
+// Allowed reports whether target, a host:port, matches one of patterns. A
+// pattern is a host:port where the port may be *. No patterns allow every
+// target.
+func Allowed(patterns []string, target string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	for _, p := range patterns {
+		ph, pp, err := net.SplitHostPort(p)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(ph, host) && (pp == "*" || pp == port) {
+			return true
+		}
+	}
+	return false
+}
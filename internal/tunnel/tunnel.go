@@ -0,0 +1,496 @@
+// Package tunnel carries TCP connections as multiplexed channels over a pair
+// session, for port forwarding.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+const (
+	// chunkSize is the most data sent in one frame.
+	chunkSize = 32 * 1024
+	// window is how many frames each side may send on a channel ahead of
+	// the partner's forward.ack; as many may wait to be written to a
+	// connection.
+	window = 64
+	// ackEvery is how many frames are written to a connection between two
+	// forward.ack.
+	ackEvery    = window / 2
+	dialTimeout = 10 * time.Second
+)
+
+// Stats counts the traffic of a tunnel. Sent and Received are seen from this
+// side: Sent is what was read from local connections and sent to the
+// partner.
+type Stats struct {
+	Connections int64
+	Active      int64
+	Sent        int64
+	Received    int64
+}
+
+type counters struct {
+	connections, active, sent, received atomic.Int64
+}
+
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Connections: c.connections.Load(),
+		Active:      c.active.Load(),
+		Sent:        c.sent.Load(),
+		Received:    c.received.Load(),
+	}
+}
+
+// Peer identifies the participant a tunnel was agreed with: the user the
+// server names as the sender of its events, and the node it runs.
+type Peer struct {
+	Sender string
+	Node   uint32
+}
+
+// tunnel is one end of a forward: it either listens for connections or dials
+// its target for each channel the other end opens. Only its peer may open,
+// close or send data on its channels.
+type tunnel struct {
+	id       uint32
+	peer     Peer
+	target   string
+	listener net.Listener
+	stats    counters
+	// next numbers the channels this end opens.
+	next uint32
+}
+
+type channelKey struct {
+	tunnel, channel uint32
+}
+
+// channel is one TCP connection through a tunnel.
+type channel struct {
+	key  channelKey
+	t    *tunnel
+	conn net.Conn
+	// queue holds the data received for the connection; it is closed once
+	// the partner has nothing more to send.
+	queue chan []byte
+	done  chan struct{}
+	// sent counts the frames sent to the partner and acked those it
+	// acknowledged; acks signals each acknowledgement to pump.
+	sent  uint64
+	acked atomic.Uint64
+	acks  chan struct{}
+
+	mu sync.Mutex
+	// localEOF is set once the connection has nothing more to send,
+	// remoteEOF once the partner has not, and drained once everything the
+	// partner sent was written.
+	localEOF, remoteEOF, drained bool
+	finished, closeWasSent       bool
+}
+
+// Mux runs the tunnels of one side of a pair session. Handle must be called
+// from a single goroutine, with each message of the session; the other
+// methods may be called concurrently.
+type Mux struct {
+	ctx    context.Context
+	node   uint32
+	pairID string
+	sess   ports.Session
+	// OnClose is called when a connection through a tunnel ends, with the
+	// error that ended it, if any.
+	OnClose func(tunnel uint32, err error)
+
+	mu       sync.Mutex
+	tunnels  map[uint32]*tunnel
+	channels map[channelKey]*channel
+}
+
+// NewMux returns a Mux sending over sess. node identifies this side in the
+// messages it sends, and must differ from the partner's.
+func NewMux(ctx context.Context, sess ports.Session, pairID string, node uint32) *Mux {
+	return &Mux{
+		ctx:      ctx,
+		node:     node,
+		pairID:   pairID,
+		sess:     sess,
+		OnClose:  func(uint32, error) {},
+		tunnels:  make(map[uint32]*tunnel),
+		channels: make(map[channelKey]*channel),
+	}
+}
+
+// Listen makes the end of tunnel id listen on addr, opening a channel to
+// peer for each connection accepted.
+func (m *Mux) Listen(id uint32, addr string, peer Peer) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &tunnel{id: id, peer: peer, listener: ln}
+	m.mu.Lock()
+	m.tunnels[id] = t
+	m.mu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			t.next++
+			ch := m.newChannel(t, t.next, conn)
+			m.mu.Unlock()
+			if err := m.sendEvent(protocol.TypeForwardOpen, protocol.ForwardChannelPayload{Node: m.node, Tunnel: id, Channel: ch.key.channel}); err != nil {
+				m.finish(ch, err)
+				continue
+			}
+			go m.pump(ch)
+			go m.drain(ch)
+		}
+	}()
+	return ln.Addr(), nil
+}
+
+// Dial makes the end of tunnel id connect to target for each channel peer
+// opens.
+func (m *Mux) Dial(id uint32, target string, peer Peer) {
+	m.mu.Lock()
+	m.tunnels[id] = &tunnel{id: id, peer: peer, target: target}
+	m.mu.Unlock()
+}
+
+// Stats returns the counters of tunnel id.
+func (m *Mux) Stats(id uint32) Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tunnels[id]; ok {
+		return t.stats.snapshot()
+	}
+	return Stats{}
+}
+
+// Remove ends tunnel id and its connections, returning its final counters.
+func (m *Mux) Remove(id uint32) Stats {
+	m.mu.Lock()
+	t, ok := m.tunnels[id]
+	if !ok {
+		m.mu.Unlock()
+		return Stats{}
+	}
+	delete(m.tunnels, id)
+	var chans []*channel
+	for key, ch := range m.channels {
+		if key.tunnel == id {
+			chans = append(chans, ch)
+		}
+	}
+	m.mu.Unlock()
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	for _, ch := range chans {
+		m.finish(ch, errors.New("tunnel closed"))
+	}
+	return t.stats.snapshot()
+}
+
+// Handle processes a message of the session, returning false when it is not
+// about a tunnel of this Mux. It never blocks: a channel whose partner sends
+// beyond the window is aborted.
+func (m *Mux) Handle(msg ports.Message) bool {
+	if msg.Type == ports.BinaryMessage {
+		f, ok := protocol.DecodeForwardFrame(msg.Data)
+		if !ok {
+			return false
+		}
+		if f.Node == m.node {
+			return true
+		}
+		if ch := m.channel(channelKey{f.Tunnel, f.Channel}); ch != nil && f.Node == ch.t.peer.Node {
+			ch.mu.Lock()
+			eof := ch.remoteEOF
+			ch.mu.Unlock()
+			if eof {
+				return true
+			}
+			ch.t.stats.received.Add(int64(len(f.Data)))
+			select {
+			case ch.queue <- append([]byte(nil), f.Data...):
+			default:
+				go m.finish(ch, errors.New("the partner sent beyond the flow control window"))
+			}
+		}
+		return true
+	}
+
+	e, err := protocol.Decode(msg.Data)
+	if err != nil {
+		return false
+	}
+	var p protocol.ForwardChannelPayload
+	switch e.Type {
+	case protocol.TypeForwardOpen, protocol.TypeForwardClose:
+		if e.DecodePayload(&p) != nil {
+			return false
+		}
+	case protocol.TypeForwardAck:
+		return m.handleAck(e)
+	default:
+		return false
+	}
+	if p.Node == m.node {
+		return true
+	}
+	key := channelKey{p.Tunnel, p.Channel}
+	if e.Type == protocol.TypeForwardOpen {
+		m.mu.Lock()
+		t, ok := m.tunnels[p.Tunnel]
+		if !ok || !t.peer.sent(e.Sender, p.Node) || t.listener != nil || m.channels[key] != nil {
+			m.mu.Unlock()
+			return ok
+		}
+		ch := m.newChannel(t, p.Channel, nil)
+		m.mu.Unlock()
+		go m.dial(ch)
+		return true
+	}
+
+	ch := m.channel(key)
+	if ch == nil {
+		m.mu.Lock()
+		_, ok := m.tunnels[p.Tunnel]
+		m.mu.Unlock()
+		return ok
+	}
+	if !ch.t.peer.sent(e.Sender, p.Node) {
+		return true
+	}
+	if p.Error != "" {
+		ch.mu.Lock()
+		ch.closeWasSent = true // the partner already knows
+		ch.mu.Unlock()
+		m.finish(ch, errors.New(p.Error))
+		return true
+	}
+	ch.mu.Lock()
+	if !ch.remoteEOF {
+		ch.remoteEOF = true
+		close(ch.queue)
+	}
+	ch.mu.Unlock()
+	return true
+}
+
+// handleAck records a forward.ack and wakes the channel's pump.
+func (m *Mux) handleAck(e protocol.Envelope) bool {
+	var p protocol.ForwardAckPayload
+	if e.DecodePayload(&p) != nil {
+		return false
+	}
+	if p.Node == m.node {
+		return true
+	}
+	ch := m.channel(channelKey{p.Tunnel, p.Channel})
+	if ch == nil || !ch.t.peer.sent(e.Sender, p.Node) {
+		return true
+	}
+	ch.acked.Store(p.Frames)
+	select {
+	case ch.acks <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// sent reports whether an event sent by sender on behalf of node comes from
+// p.
+func (p Peer) sent(sender string, node uint32) bool {
+	return p.Sender == sender && p.Node == node
+}
+
+// Close ends every tunnel.
+func (m *Mux) Close() {
+	m.mu.Lock()
+	ids := make([]uint32, 0, len(m.tunnels))
+	for id := range m.tunnels {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	for _, id := range ids {
+		m.Remove(id)
+	}
+}
+
+func (m *Mux) channel(key channelKey) *channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.channels[key]
+}
+
+// newChannel registers a channel; m.mu must be held.
+func (m *Mux) newChannel(t *tunnel, id uint32, conn net.Conn) *channel {
+	ch := &channel{
+		key:   channelKey{t.id, id},
+		t:     t,
+		conn:  conn,
+		queue: make(chan []byte, window),
+		done:  make(chan struct{}),
+		acks:  make(chan struct{}, 1),
+	}
+	m.channels[ch.key] = ch
+	t.stats.connections.Add(1)
+	t.stats.active.Add(1)
+	return ch
+}
+
+// dial connects a channel opened by the partner to the tunnel's target.
+func (m *Mux) dial(ch *channel) {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(m.ctx, "tcp", ch.t.target)
+	if err != nil {
+		m.finish(ch, err)
+		return
+	}
+	ch.mu.Lock()
+	if ch.finished {
+		ch.mu.Unlock()
+		conn.Close()
+		return
+	}
+	ch.conn = conn
+	ch.mu.Unlock()
+	go m.pump(ch)
+	m.drain(ch)
+}
+
+// pump sends what the connection reads to the partner, keeping at most
+// window frames ahead of its acknowledgements.
+func (m *Mux) pump(ch *channel) {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := ch.conn.Read(buf)
+		if n > 0 {
+			for ch.sent-ch.acked.Load() >= window {
+				select {
+				case <-ch.acks:
+				case <-ch.done:
+					return
+				}
+			}
+			ch.sent++
+			if serr := m.sess.Send(m.ctx, ports.BinaryMessage, protocol.EncodeForwardFrame(protocol.ForwardFrame{Node: m.node, Tunnel: ch.key.tunnel, Channel: ch.key.channel, Data: buf[:n]})); serr != nil {
+				m.finish(ch, serr)
+				return
+			}
+			ch.t.stats.sent.Add(int64(n))
+		}
+		if errors.Is(err, io.EOF) {
+			m.sendClose(ch, "")
+			ch.mu.Lock()
+			ch.localEOF = true
+			both := ch.drained
+			ch.mu.Unlock()
+			if both {
+				m.finish(ch, nil)
+			}
+			return
+		}
+		if err != nil {
+			m.finish(ch, err)
+			return
+		}
+	}
+}
+
+// drain writes what the partner sends to the connection, acknowledging
+// every ackEvery frames.
+func (m *Mux) drain(ch *channel) {
+	var written uint64
+	for {
+		select {
+		case data, ok := <-ch.queue:
+			if !ok {
+				if cw, ok := ch.conn.(interface{ CloseWrite() error }); ok {
+					_ = cw.CloseWrite()
+				}
+				ch.mu.Lock()
+				ch.drained = true
+				both := ch.localEOF
+				ch.mu.Unlock()
+				if both {
+					m.finish(ch, nil)
+				}
+				return
+			}
+			if _, err := ch.conn.Write(data); err != nil {
+				m.finish(ch, err)
+				return
+			}
+			if written++; written%ackEvery == 0 {
+				if err := m.sendEvent(protocol.TypeForwardAck, protocol.ForwardAckPayload{Node: m.node, Tunnel: ch.key.tunnel, Channel: ch.key.channel, Frames: written}); err != nil {
+					m.finish(ch, err)
+					return
+				}
+			}
+		case <-ch.done:
+			return
+		}
+	}
+}
+
+func (m *Mux) sendClose(ch *channel, reason string) {
+	ch.mu.Lock()
+	if ch.closeWasSent && reason != "" {
+		ch.mu.Unlock()
+		return
+	}
+	if reason != "" {
+		ch.closeWasSent = true
+	}
+	ch.mu.Unlock()
+	_ = m.sendEvent(protocol.TypeForwardClose, protocol.ForwardChannelPayload{Node: m.node, Tunnel: ch.key.tunnel, Channel: ch.key.channel, Error: reason})
+}
+
+// finish ends a channel, aborting it on the partner's side too when err is
+// set.
+func (m *Mux) finish(ch *channel, err error) {
+	ch.mu.Lock()
+	if ch.finished {
+		ch.mu.Unlock()
+		return
+	}
+	ch.finished = true
+	close(ch.done)
+	conn := ch.conn
+	ch.mu.Unlock()
+
+	if err != nil {
+		m.sendClose(ch, err.Error())
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	m.mu.Lock()
+	delete(m.channels, ch.key)
+	m.mu.Unlock()
+	ch.t.stats.active.Add(-1)
+	m.OnClose(ch.key.tunnel, err)
+}
+
+func (m *Mux) sendEvent(eventType string, payload interface{}) error {
+	data, err := protocol.Encode(eventType, m.pairID, payload)
+	if err != nil {
+		return err
+	}
+	return m.sess.Send(m.ctx, ports.TextMessage, data)
+}
@@ -0,0 +1,247 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ravenpair/cli/internal/ports"
+	"github.com/ravenpair/cli/internal/protocol"
+)
+
+func TestParseSpec(t *testing.T) {
+	cases := []struct {
+		in      string
+		reverse bool
+		want    Spec
+	}{
+		{"L:8080:localhost:3000", false, Spec{Listen: "localhost:8080", Target: "localhost:3000"}},
+		{"8080:db:5432", true, Spec{Reverse: true, Listen: "localhost:8080", Target: "db:5432"}},
+		{"R:0.0.0.0:9000:127.0.0.1:3000", false, Spec{Reverse: true, Listen: "0.0.0.0:9000", Target: "127.0.0.1:3000"}},
+		{"L:[::1]:8080:[fe80::1]:3000", false, Spec{Listen: "[::1]:8080", Target: "[fe80::1]:3000"}},
+		{"[::]:8080:localhost:3000", false, Spec{Listen: "[::]:8080", Target: "localhost:3000"}},
+	}
+	for _, c := range cases {
+		got, err := ParseSpec(c.in, c.reverse)
+		if err != nil || got != c.want {
+			t.Errorf("ParseSpec(%q) = %+v, %v; want %+v", c.in, got, err, c.want)
+		}
+	}
+	for _, bad := range []string{"8080", "L:8080:localhost", "x:localhost:3000", "8080::3000", "8080:localhost:70000", "8080:localhost:0", "[::1:8080:localhost:3000", "8080:::1:3000"} {
+		if _, err := ParseSpec(bad, false); err == nil {
+			t.Errorf("ParseSpec(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	patterns := []string{"localhost:3000", "127.0.0.1:*"}
+	for target, want := range map[string]bool{
+		"localhost:3000":  true,
+		"LOCALHOST:3000":  true,
+		"localhost:3001":  false,
+		"127.0.0.1:22":    true,
+		"example.com:443": false,
+	} {
+		if got := Allowed(patterns, target); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", target, got, want)
+		}
+	}
+	if !Allowed(nil, "anything:1") {
+		t.Error("expected no patterns to allow every target")
+	}
+}
+
+// pipeSession delivers what is sent to the Mux of the other end.
+type pipeSession struct {
+	to chan ports.Message
+}
+
+func (p *pipeSession) Events() <-chan ports.Event { return nil }
+func (p *pipeSession) Close(int, string) error    { return nil }
+func (p *pipeSession) Stats() ports.SessionStats  { return ports.SessionStats{} }
+func (p *pipeSession) Send(_ context.Context, msgType int, data []byte) error {
+	p.to <- ports.Message{Type: msgType, Data: append([]byte(nil), data...)}
+	return nil
+}
+
+func TestMuxForwardsConnections(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	toA, toB := make(chan ports.Message, 16), make(chan ports.Message, 16)
+	a := NewMux(ctx, &pipeSession{to: toB}, "p1", 1)
+	b := NewMux(ctx, &pipeSession{to: toA}, "p1", 2)
+	defer a.Close()
+	defer b.Close()
+	closed := make(chan error, 2)
+	a.OnClose = func(_ uint32, err error) { closed <- err }
+	for mux, in := range map[*Mux]chan ports.Message{a: toA, b: toB} {
+		go func() {
+			for msg := range in {
+				mux.Handle(msg)
+			}
+		}()
+	}
+
+	b.Dial(7, echo.Addr().String(), Peer{Node: 1})
+	addr, err := a.Listen(7, "127.0.0.1:0", Peer{Node: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello through the tunnel"))
+	conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(got) != "hello through the tunnel" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("expected a clean close, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection did not end")
+	}
+	if s := a.Stats(7); s.Connections != 1 || s.Active != 0 || s.Sent != 24 || s.Received != 24 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestMuxAbortsAChannelBeyondTheWindow(t *testing.T) {
+	out := make(chan ports.Message, 16)
+	m := NewMux(context.Background(), &pipeSession{to: out}, "p1", 2)
+	m.Dial(7, "unused:1", Peer{Node: 1})
+	local, remote := net.Pipe() // nothing reads remote
+	defer remote.Close()
+	m.mu.Lock()
+	ch := m.newChannel(m.tunnels[7], 1, local)
+	m.mu.Unlock()
+	go m.drain(ch)
+
+	handled := make(chan struct{})
+	go func() {
+		for i := 0; i < window+2; i++ {
+			m.Handle(ports.Message{Type: ports.BinaryMessage, Data: protocol.EncodeForwardFrame(protocol.ForwardFrame{Node: 1, Tunnel: 7, Channel: 1, Data: []byte("x")})})
+		}
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle blocked on a connection that does not read")
+	}
+	select {
+	case msg := <-out:
+		e, err := protocol.Decode(msg.Data)
+		var p protocol.ForwardChannelPayload
+		if err != nil || e.Type != protocol.TypeForwardClose || e.DecodePayload(&p) != nil || p.Error == "" {
+			t.Errorf("expected the channel to be aborted, got %s", msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel was not aborted")
+	}
+}
+
+func TestMuxKeepsToTheWindow(t *testing.T) {
+	out := make(chan ports.Message, 2*window)
+	m := NewMux(context.Background(), &pipeSession{to: out}, "p1", 2)
+	m.Dial(7, "unused:1", Peer{Node: 1})
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m.mu.Lock()
+	ch := m.newChannel(m.tunnels[7], 1, local)
+	m.mu.Unlock()
+	go m.pump(ch)
+	go func() {
+		for i := 0; i < window+1; i++ {
+			if _, err := remote.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	frames := func(want int) {
+		t.Helper()
+		for i := 0; i < want; i++ {
+			select {
+			case <-out:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got %d frames, want %d", i, want)
+			}
+		}
+		select {
+		case msg := <-out:
+			t.Fatalf("unexpected message beyond the window: %q", msg.Data)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	frames(window)
+	data, err := protocol.Encode(protocol.TypeForwardAck, "p1", protocol.ForwardAckPayload{Node: 1, Tunnel: 7, Channel: 1, Frames: ackEvery})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Handle(ports.Message{Type: ports.TextMessage, Data: data}) {
+		t.Fatal("forward.ack was not handled")
+	}
+	frames(1)
+}
+
+func TestMuxIgnoresOtherParticipants(t *testing.T) {
+	out := make(chan ports.Message, 16)
+	m := NewMux(context.Background(), &pipeSession{to: out}, "p1", 2)
+	m.Dial(7, "127.0.0.1:1", Peer{Sender: "bo", Node: 1})
+	event := func(sender, eventType string, payload interface{}) ports.Message {
+		raw, _ := json.Marshal(payload)
+		data, _ := json.Marshal(protocol.Envelope{Type: eventType, PairID: "p1", Sender: sender, Payload: raw})
+		return ports.Message{Type: ports.TextMessage, Data: data}
+	}
+
+	for _, msg := range []ports.Message{
+		event("cy", protocol.TypeForwardOpen, protocol.ForwardChannelPayload{Node: 1, Tunnel: 7, Channel: 1}),
+		event("bo", protocol.TypeForwardOpen, protocol.ForwardChannelPayload{Node: 3, Tunnel: 7, Channel: 1}),
+	} {
+		if !m.Handle(msg) {
+			t.Fatalf("expected %s to be handled", msg.Data)
+		}
+	}
+	if s := m.Stats(7); s.Connections != 0 {
+		t.Fatalf("a channel was opened by someone else: %+v", s)
+	}
+
+	m.mu.Lock()
+	ch := m.newChannel(m.tunnels[7], 1, nil)
+	m.mu.Unlock()
+	m.Handle(ports.Message{Type: ports.BinaryMessage, Data: protocol.EncodeForwardFrame(protocol.ForwardFrame{Node: 3, Tunnel: 7, Channel: 1, Data: []byte("injected")})})
+	m.Handle(event("cy", protocol.TypeForwardClose, protocol.ForwardChannelPayload{Node: 1, Tunnel: 7, Channel: 1, Error: "aborted"}))
+	if len(ch.queue) != 0 || m.channel(ch.key) == nil {
+		t.Error("expected the frame and the close of someone else to be ignored")
+	}
+}
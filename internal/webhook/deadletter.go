@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an event that could not be delivered, as recorded in the
+// dead-letter file.
+type DeadLetter struct {
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id"`
+	Event    string    `json:"event,omitempty"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	// Body is the request body that was refused; Message is the event as
+	// received, so it can be replayed with a different body template.
+	Body    string          `json:"body"`
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// DeadLetterFile appends undeliverable events to a file, one JSON object per
+// line. The file is created on first use, readable by its owner only since
+// events may hold private session content.
+type DeadLetterFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewDeadLetterFile returns a DeadLetterFile writing to path.
+func NewDeadLetterFile(path string) *DeadLetterFile {
+	return &DeadLetterFile{path: path}
+}
+
+// Path returns the file's location.
+func (d *DeadLetterFile) Path() string {
+	return d.path
+}
+
+// Add appends l to the file.
+func (d *DeadLetterFile) Add(l DeadLetter) error {
+	line, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
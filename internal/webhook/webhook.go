@@ -0,0 +1,188 @@
+// Package webhook delivers events to HTTP endpoints: it signs each request,
+// retries failed deliveries and records the ones that could not be made.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header names set on every delivery.
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+	// keyed with the shared secret, as GitHub webhooks do.
+	SignatureHeader = "X-RavenPair-Signature-256"
+	// DeliveryHeader identifies the delivery; retries reuse the same ID.
+	DeliveryHeader = "X-RavenPair-Delivery"
+	// EventHeader names the event type, when known.
+	EventHeader = "X-RavenPair-Event"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultBackoff    = time.Second
+	maxBackoff        = 30 * time.Second
+	maxErrorBodyBytes = 512
+)
+
+// Sender POSTs events to one URL.
+type Sender struct {
+	url         string
+	secret      []byte
+	contentType string
+	retries     int
+	backoff     time.Duration
+	client      *http.Client
+}
+
+// Option configures optional behaviour of the Sender.
+type Option func(*Sender)
+
+// WithSecret signs every request with secret; see SignatureHeader.
+func WithSecret(secret []byte) Option {
+	return func(s *Sender) {
+		s.secret = secret
+	}
+}
+
+// WithContentType sets the Content-Type of requests; the default is
+// application/json.
+func WithContentType(contentType string) Option {
+	return func(s *Sender) {
+		s.contentType = contentType
+	}
+}
+
+// WithRetries makes the Sender retry a failed delivery up to retries times,
+// waiting backoff before the first retry and twice as long before each
+// further one, up to 30 seconds.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(s *Sender) {
+		s.retries = retries
+		s.backoff = backoff
+	}
+}
+
+// WithHTTPClient replaces the client used for deliveries.
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Sender) {
+		s.client = c
+	}
+}
+
+// New returns a Sender that POSTs to url.
+func New(url string, opts ...Option) *Sender {
+	s := &Sender{
+		url:         url,
+		contentType: "application/json",
+		backoff:     defaultBackoff,
+		client:      &http.Client{Timeout: defaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Sign returns the value of SignatureHeader for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// StatusError is returned for a delivery the endpoint answered with an error
+// status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("endpoint answered HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("endpoint answered HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether a delivery that failed with err may succeed if
+// tried again: network errors, 408, 429 and 5xx statuses are retried; other
+// statuses mean the endpoint rejected the event.
+func retryable(err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return true
+	}
+	return se.StatusCode == http.StatusRequestTimeout || se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+}
+
+// Deliver POSTs body, retrying as configured, and returns how many attempts
+// were made. The last error is returned when every attempt failed, when the
+// endpoint rejected the event, or when ctx was cancelled while waiting to
+// retry.
+func (s *Sender) Deliver(ctx context.Context, id, eventType string, body []byte) (int, error) {
+	wait := s.backoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := s.post(ctx, id, eventType, body)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt > s.retries || !retryable(err) {
+			return attempt, err
+		}
+		delay := wait
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+// post makes one delivery attempt. It also returns how long the endpoint
+// asked to wait with Retry-After, if it did.
+func (s *Sender) post(ctx context.Context, id, eventType string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	req.Header.Set("User-Agent", "ravenpair-relay")
+	req.Header.Set(DeliveryHeader, id)
+	if eventType != "" {
+		req.Header.Set(EventHeader, eventType)
+	}
+	if s.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(s.secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+	var retryAfter time.Duration
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		retryAfter = min(time.Duration(secs)*time.Second, maxBackoff)
+	}
+	return retryAfter, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(snippet))}
+}
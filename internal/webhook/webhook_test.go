@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverSignsRequests(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	s := New(srv.URL, WithSecret([]byte("s3cret")), WithContentType("text/plain"))
+	attempts, err := s.Deliver(context.Background(), "p1-7", "chat", []byte("hello"))
+	if err != nil || attempts != 1 {
+		t.Fatalf("Deliver = %d, %v", attempts, err)
+	}
+	r, body := <-got, <-bodies
+	if string(body) != "hello" || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected request %s %q %v", r.Method, body, r.Header)
+	}
+	// echo -n hello | openssl dgst -sha256 -hmac s3cret
+	want := "sha256=e5a01537481fa0b2c697f787c7aff885412cf0760d08e08502259b39d2d6ae68"
+	if got := r.Header.Get(SignatureHeader); got != want {
+		t.Errorf("unexpected signature %q", got)
+	}
+	if r.Header.Get(DeliveryHeader) != "p1-7" || r.Header.Get(EventHeader) != "chat" {
+		t.Errorf("unexpected delivery headers: %v", r.Header)
+	}
+}
+
+func TestDeliverRetriesTemporaryFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	attempts, err := New(srv.URL, WithRetries(5, time.Millisecond)).Deliver(context.Background(), "1", "", []byte("{}"))
+	if err != nil || attempts != 3 {
+		t.Errorf("Deliver = %d, %v; want success on the third attempt", attempts, err)
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get(DeliveryHeader) == "rejected" {
+			http.Error(w, "bad payload", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	s := New(srv.URL, WithRetries(2, time.Millisecond))
+
+	attempts, err := s.Deliver(context.Background(), "down", "", nil)
+	var se *StatusError
+	if attempts != 3 || !errors.As(err, &se) || se.StatusCode != http.StatusBadGateway {
+		t.Errorf("Deliver = %d, %v; want 3 attempts ending in 502", attempts, err)
+	}
+
+	calls.Store(0)
+	attempts, err = s.Deliver(context.Background(), "rejected", "", nil)
+	if attempts != 1 || calls.Load() != 1 || !errors.As(err, &se) || se.Body != "bad payload" {
+		t.Errorf("Deliver = %d, %v; want a single attempt for a 400", attempts, err)
+	}
+}
+
+func TestDeliverStopsRetryingWhenCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := New(srv.URL, WithRetries(10, time.Hour)).Deliver(ctx, "1", "", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Deliver kept waiting after cancellation")
+	}
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	d := NewDeadLetterFile(path)
+	for _, id := range []string{"1", "2"} {
+		if err := d.Add(DeadLetter{ID: id, Attempts: 3, Error: "down", Body: "{}", Message: json.RawMessage(`{"seq":1}`)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if info, _ := f.Stat(); info.Mode().Perm() != 0o600 {
+		t.Errorf("dead-letter file mode %v, want 0600", info.Mode().Perm())
+	}
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, l.ID)
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("unexpected dead letters: %v", ids)
+	}
+}